@@ -0,0 +1,200 @@
+// Package anlz parses the ANLZ0000.DAT, .EXT and .2EX analysis files rekordbox
+// writes alongside the export database of USB and SD media.
+//
+// Analysis files are made up of tagged sections. Each tag starts with a four
+// character code identifying its kind, the length of its header and the
+// length of the entire tag. All values are big endian.
+package anlz
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"unicode/utf16"
+)
+
+// ErrMalformed is wrapped by errors returned when the data is not a valid
+// analysis file or tag.
+var ErrMalformed = errors.New("Malformed analysis file")
+
+// ErrTagNotFound is returned when the analysis file does not include a tag of
+// the requested kind.
+var ErrTagNotFound = errors.New("The analysis file does not include the tag")
+
+// fileMagic is the code the file header starts with.
+const fileMagic = "PMAI"
+
+// Known tag kinds.
+const (
+	TagPath                 = "PPTH"
+	TagBeatGrid             = "PQTZ"
+	TagCueList              = "PCOB"
+	TagCueListExt           = "PCO2"
+	TagWaveformPreview      = "PWAV"
+	TagWaveformTinyPreview  = "PWV2"
+	TagWaveformDetail       = "PWV3"
+	TagWaveformColorPreview = "PWV4"
+	TagWaveformColorDetail  = "PWV5"
+	TagWaveform3BandPreview = "PWV6"
+	TagWaveform3BandDetail  = "PWV7"
+	TagSongStructure        = "PSSI"
+)
+
+// tagHeaderSize is the size of the fields common to the header of each tag.
+const tagHeaderSize = 0x0c
+
+// malformed constructs an error wrapping ErrMalformed.
+func malformed(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrMalformed, fmt.Sprintf(format, args...))
+}
+
+// Tag is a single tagged section of an analysis file.
+type Tag struct {
+	Kind string
+
+	// Header is the complete header of the tag, including the kind and
+	// lengths. Data is the content of the tag following the header.
+	Header []byte
+	Data   []byte
+
+	// raw is the complete tag, the header followed by the data.
+	raw []byte
+}
+
+// ParseTag parses the tag at the start of the data, returning the number of
+// bytes the tag occupies.
+func ParseTag(data []byte) (*Tag, int, error) {
+	if len(data) < tagHeaderSize {
+		return nil, 0, malformed("tag is too short (%d bytes)", len(data))
+	}
+
+	headerSize := binary.BigEndian.Uint32(data[0x04:])
+	tagSize := binary.BigEndian.Uint32(data[0x08:])
+
+	if headerSize < tagHeaderSize || headerSize > tagSize || uint64(tagSize) > uint64(len(data)) {
+		return nil, 0, malformed("tag %q has invalid lengths", data[:4])
+	}
+
+	tag := &Tag{
+		Kind:   string(data[:4]),
+		Header: data[:headerSize],
+		Data:   data[headerSize:tagSize],
+		raw:    data[:tagSize],
+	}
+
+	return tag, int(tagSize), nil
+}
+
+// u32 reads the header field of the tag at the offset, zero is returned when
+// the header is too short.
+func (t *Tag) u32(at int) uint32 {
+	if at+4 > len(t.Header) {
+		return 0
+	}
+
+	return binary.BigEndian.Uint32(t.Header[at:])
+}
+
+// u16 reads the header field of the tag at the offset, zero is returned when
+// the header is too short.
+func (t *Tag) u16(at int) uint16 {
+	if at+2 > len(t.Header) {
+		return 0
+	}
+
+	return binary.BigEndian.Uint16(t.Header[at:])
+}
+
+// File is a parsed analysis file.
+type File struct {
+	Tags []*Tag
+}
+
+// Parse parses the contents of an analysis file.
+func Parse(data []byte) (*File, error) {
+	if len(data) < tagHeaderSize || string(data[:4]) != fileMagic {
+		return nil, malformed("file does not start with the %s header", fileMagic)
+	}
+
+	headerSize := binary.BigEndian.Uint32(data[0x04:])
+	fileSize := binary.BigEndian.Uint32(data[0x08:])
+
+	if headerSize < tagHeaderSize || headerSize > fileSize || uint64(fileSize) > uint64(len(data)) {
+		return nil, malformed("file header has invalid lengths")
+	}
+
+	file := &File{Tags: []*Tag{}}
+
+	for offset := int(headerSize); offset < int(fileSize); {
+		tag, size, err := ParseTag(data[offset:fileSize])
+		if err != nil {
+			return nil, err
+		}
+
+		file.Tags = append(file.Tags, tag)
+		offset += size
+	}
+
+	return file, nil
+}
+
+// Open reads and parses the analysis file at the path.
+func Open(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(data)
+}
+
+// Tag returns the first tag of the kind, or nil if the file does not include
+// a tag of the kind.
+func (f *File) Tag(kind string) *Tag {
+	for _, t := range f.Tags {
+		if t.Kind == kind {
+			return t
+		}
+	}
+
+	return nil
+}
+
+// find returns the first tag of the kind, or ErrTagNotFound.
+func (f *File) find(kind string) (*Tag, error) {
+	if t := f.Tag(kind); t != nil {
+		return t, nil
+	}
+
+	return nil, ErrTagNotFound
+}
+
+// decodeUTF16 decodes big endian UTF-16 text, stripping the trailing NUL.
+func decodeUTF16(data []byte) string {
+	units := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		units = append(units, binary.BigEndian.Uint16(data[i:]))
+	}
+
+	if n := len(units); n > 0 && units[n-1] == 0 {
+		units = units[:n-1]
+	}
+
+	return string(utf16.Decode(units))
+}
+
+// Path returns the path of the audio file the analysis file describes.
+func (f *File) Path() (string, error) {
+	t, err := f.find(TagPath)
+	if err != nil {
+		return "", err
+	}
+
+	size := int(t.u32(0x0c))
+	if size > len(t.Data) {
+		return "", malformed("path is too long (%d bytes)", size)
+	}
+
+	return decodeUTF16(t.Data[:size]), nil
+}
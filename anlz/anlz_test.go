@@ -0,0 +1,334 @@
+package anlz
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// tagFixture constructs a tag of the kind. The header holds the fields
+// following the common kind and lengths.
+func tagFixture(kind string, header, data []byte) []byte {
+	headerSize := tagHeaderSize + len(header)
+
+	tag := make([]byte, tagHeaderSize, headerSize+len(data))
+	copy(tag, kind)
+	binary.BigEndian.PutUint32(tag[0x04:], uint32(headerSize))
+	binary.BigEndian.PutUint32(tag[0x08:], uint32(headerSize+len(data)))
+
+	return append(append(tag, header...), data...)
+}
+
+// fileFixture constructs an analysis file of the tags.
+func fileFixture(tags ...[]byte) []byte {
+	file := make([]byte, 0x1c)
+	copy(file, fileMagic)
+
+	for _, t := range tags {
+		file = append(file, t...)
+	}
+
+	binary.BigEndian.PutUint32(file[0x04:], 0x1c)
+	binary.BigEndian.PutUint32(file[0x08:], uint32(len(file)))
+
+	return file
+}
+
+func TestParseMalformed(t *testing.T) {
+	valid := fileFixture(tagFixture(TagPath, make([]byte, 4), nil))
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", []byte{}},
+		{"wrong magic", append([]byte("PMAX"), valid[4:]...)},
+		{"tag larger than file", func() []byte {
+			data := append([]byte{}, valid...)
+			binary.BigEndian.PutUint32(data[0x1c+0x08:], 0x100)
+			return data
+		}()},
+		{"truncated tag header", fileFixture([]byte("PPTH"))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.data); !errors.Is(err, ErrMalformed) {
+				t.Errorf("got error %v, want ErrMalformed", err)
+			}
+		})
+	}
+}
+
+func TestPath(t *testing.T) {
+	path := []byte{0x00, '/', 0x00, 'a', 0x00, '.', 0x00, 'm', 0x00, 'p', 0x00, '3', 0x00, 0x00}
+
+	file, err := Parse(fileFixture(tagFixture(TagPath, []byte{0x00, 0x00, 0x00, 0x0e}, path)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := file.Path()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != "/a.mp3" {
+		t.Errorf("got path %q, want %q", got, "/a.mp3")
+	}
+
+	if _, err := file.BeatGrid(); err != ErrTagNotFound {
+		t.Errorf("got error %v for a missing tag, want ErrTagNotFound", err)
+	}
+}
+
+func TestBeatGrid(t *testing.T) {
+	header := []byte{
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x08, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x02, // number of beats
+	}
+
+	beats := []byte{
+		0x00, 0x01, 0x2f, 0x44, 0x00, 0x00, 0x00, 0x64,
+		0x00, 0x02, 0x2f, 0x44, 0x00, 0x00, 0x02, 0x4b,
+	}
+
+	file, err := Parse(fileFixture(tagFixture(TagBeatGrid, header, beats)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := file.BeatGrid()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Beat{
+		{BeatInMeasure: 1, Tempo: 12100, Time: 100 * time.Millisecond},
+		{BeatInMeasure: 2, Tempo: 12100, Time: 587 * time.Millisecond},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// The tag claims more beats than it holds
+	file, err = Parse(fileFixture(tagFixture(TagBeatGrid, header, beats[:8])))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := file.BeatGrid(); !errors.Is(err, ErrMalformed) {
+		t.Errorf("got error %v, want ErrMalformed", err)
+	}
+}
+
+func TestCueLists(t *testing.T) {
+	// A PCPT memory point at 1 second, which is not a loop
+	pcpt := tagFixture("PCPT", []byte{
+		0x00, 0x00, 0x00, 0x00, // hot cue
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+	}, []byte{
+		0x01, 0x00, 0x00, 0x00, // kind
+		0x00, 0x00, 0x03, 0xe8, // time
+		0xff, 0xff, 0xff, 0xff, // loop time
+	})
+
+	pcob := tagFixture(TagCueList, []byte{
+		0x00, 0x00, 0x00, 0x00, // memory points
+		0x00, 0x00, 0x00, 0x01, // number of cues
+		0x00, 0x00, 0x00, 0x00,
+	}, pcpt)
+
+	// A PCP2 hot cue B loop from 2 to 3 seconds, with the comment "Hi" and
+	// a color
+	pcp2 := tagFixture("PCP2", []byte{
+		0x00, 0x00, 0x00, 0x02, // hot cue
+	}, []byte{
+		0x02, 0x00, 0x00, 0x00, // kind
+		0x00, 0x00, 0x07, 0xd0, // time
+		0x00, 0x00, 0x0b, 0xb8, // loop time
+		0x05, 0x00, 0x00, 0x00, // color ID
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x06, // comment size
+		0x00, 'H', 0x00, 'i', 0x00, 0x00,
+		0x05, 0x10, 0x20, 0x30, // color
+	})
+
+	pco2 := tagFixture(TagCueListExt, []byte{
+		0x00, 0x00, 0x00, 0x01, // hot cues
+		0x00, 0x01, 0x00, 0x00, // number of cues
+	}, pcp2)
+
+	tests := []struct {
+		name string
+		data []byte
+		want []*CueList
+	}{
+		{
+			name: "cue list",
+			data: fileFixture(pcob),
+			want: []*CueList{{
+				Type: CueListMemoryPoints,
+				Cues: []Cue{{Time: time.Second}},
+			}},
+		},
+		{
+			name: "extended cue list preferred",
+			data: fileFixture(pcob, pco2),
+			want: []*CueList{{
+				Type:     CueListHotCues,
+				Extended: true,
+				Cues: []Cue{{
+					HotCue:   2,
+					Time:     2 * time.Second,
+					Loop:     true,
+					LoopTime: 3 * time.Second,
+					Comment:  "Hi",
+					ColorID:  5,
+					HasColor: true,
+					Red:      0x10,
+					Green:    0x20,
+					Blue:     0x30,
+				}},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := Parse(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := file.CueLists()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWaveform(t *testing.T) {
+	tests := []struct {
+		name string
+		tag  []byte
+		want *Waveform
+	}{
+		{
+			name: "preview",
+			tag:  tagFixture(TagWaveformPreview, []byte{0x00, 0x00, 0x00, 0x03, 0x00, 0x01, 0x00, 0x00}, []byte{0x1f, 0xe5, 0x00}),
+			want: &Waveform{Kind: TagWaveformPreview, EntrySize: 1, Entries: []byte{0x1f, 0xe5, 0x00}},
+		},
+		{
+			name: "color preview",
+			tag: tagFixture(TagWaveformColorPreview, []byte{
+				0x00, 0x00, 0x00, 0x06,
+				0x00, 0x00, 0x00, 0x01,
+				0x00, 0x00, 0x00, 0x00,
+			}, []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06}),
+			want: &Waveform{Kind: TagWaveformColorPreview, EntrySize: 6, Entries: []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := Parse(fileFixture(tt.tag))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := file.Waveform(tt.want.Kind)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+
+			if got.Len() != len(tt.want.Entries)/tt.want.EntrySize {
+				t.Errorf("got %d segments", got.Len())
+			}
+		})
+	}
+
+	// The tag claims more entries than it holds
+	file, err := Parse(fileFixture(tagFixture(TagWaveform3BandDetail, []byte{
+		0x00, 0x00, 0x00, 0x03,
+		0x00, 0x00, 0x00, 0x02,
+		0x00, 0x00, 0x00, 0x00,
+	}, []byte{0x01, 0x02, 0x03})))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := file.Waveform(TagWaveform3BandDetail); !errors.Is(err, ErrMalformed) {
+		t.Errorf("got error %v, want ErrMalformed", err)
+	}
+}
+
+func TestSongStructure(t *testing.T) {
+	header := []byte{
+		0x00, 0x00, 0x00, 0x18, // entry size
+		0x00, 0x02, // number of phrases
+		0x00, 0x01, // mood
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x81, // end beat
+		0x00, 0x00,
+		0x03, // bank
+		0x00,
+	}
+
+	phrases := []byte{
+		0x00, 0x01, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x02, 0x00, 0x41, 0x00, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x7d,
+	}
+
+	plain := tagFixture(TagSongStructure, header, phrases)
+
+	// Rekordbox 6 masks the tag starting at the mood
+	masked := append([]byte{}, plain...)
+	for i := phraseMaskStart; i < len(masked); i++ {
+		masked[i] ^= phraseMask[(i-phraseMaskStart)%len(phraseMask)] + 2
+	}
+
+	want := &SongStructure{
+		Mood:    MoodHigh,
+		EndBeat: 0x81,
+		Bank:    3,
+		Phrases: []Phrase{
+			{Index: 1, Beat: 1, EndBeat: 0x41, Kind: 1, Name: "Intro"},
+			{Index: 2, Beat: 0x41, EndBeat: 0x81, Kind: 5, Name: "Chorus", Fill: true, FillBeat: 0x7d},
+		},
+	}
+
+	for name, tag := range map[string][]byte{"plain": plain, "masked": masked} {
+		t.Run(name, func(t *testing.T) {
+			file, err := Parse(fileFixture(tag))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := file.SongStructure()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
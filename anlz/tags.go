@@ -0,0 +1,419 @@
+package anlz
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// Beat is a single beat of a beat grid.
+type Beat struct {
+	// BeatInMeasure is the position of the beat within its bar, from 1 to 4.
+	BeatInMeasure uint16
+
+	// Tempo is the BPM of the track at the beat multiplied by 100.
+	Tempo uint16
+
+	// Time is the position of the beat from the start of the track, with
+	// millisecond precision.
+	Time time.Duration
+}
+
+// beatEntrySize is the number of bytes describing each beat of a PQTZ tag.
+const beatEntrySize = 8
+
+// BeatGrid decodes the beat grid of the file, found in DAT files.
+func (f *File) BeatGrid() ([]Beat, error) {
+	t, err := f.find(TagBeatGrid)
+	if err != nil {
+		return nil, err
+	}
+
+	count := int(t.u32(0x14))
+	if count*beatEntrySize > len(t.Data) {
+		return nil, malformed("beat grid lists %d beats", count)
+	}
+
+	beats := make([]Beat, count)
+	for i := range beats {
+		entry := t.Data[i*beatEntrySize:]
+
+		beats[i] = Beat{
+			BeatInMeasure: binary.BigEndian.Uint16(entry[0x00:]),
+			Tempo:         binary.BigEndian.Uint16(entry[0x02:]),
+			Time:          time.Duration(binary.BigEndian.Uint32(entry[0x04:])) * time.Millisecond,
+		}
+	}
+
+	return beats, nil
+}
+
+// CueListType identifies the kind of cues a PCOB or PCO2 tag lists.
+type CueListType uint32
+
+// Known cue list types.
+const (
+	CueListMemoryPoints CueListType = 0
+	CueListHotCues      CueListType = 1
+)
+
+// cueKindLoop is the kind of cues which are loops.
+const cueKindLoop = 0x02
+
+// noLoop is the loop time of cues which are not loops.
+const noLoop = 0xffffffff
+
+// Cue is a memory point, hot cue or saved loop.
+type Cue struct {
+	// HotCue is the hot cue button the cue is assigned to, from 1 for hot cue
+	// A. Zero for memory points.
+	HotCue uint32
+
+	Time time.Duration
+
+	// Loop is set for saved loops, which end at the LoopTime.
+	Loop     bool
+	LoopTime time.Duration
+
+	// Comment, ColorID and the color components are only known for cues of
+	// extended cue lists. ColorID is the index of the color within the
+	// rekordbox color palette, and HasColor is set when the cue has an RGB
+	// color.
+	Comment  string
+	ColorID  uint8
+	HasColor bool
+	Red      uint8
+	Green    uint8
+	Blue     uint8
+}
+
+// CueList is a single PCOB or PCO2 tag, listing either memory points or hot
+// cues of the track.
+type CueList struct {
+	Type     CueListType
+	Extended bool
+	Cues     []Cue
+}
+
+// CueLists decodes the cue lists of the file. Extended cue lists, found in
+// EXT files, are returned when the file includes them, otherwise the cue
+// lists found in DAT files are returned.
+func (f *File) CueLists() ([]*CueList, error) {
+	kind := TagCueListExt
+	if f.Tag(kind) == nil {
+		kind = TagCueList
+	}
+
+	lists := []*CueList{}
+
+	for _, t := range f.Tags {
+		if t.Kind != kind {
+			continue
+		}
+
+		list, err := parseCueList(t)
+		if err != nil {
+			return nil, err
+		}
+
+		lists = append(lists, list)
+	}
+
+	if len(lists) == 0 {
+		return nil, ErrTagNotFound
+	}
+
+	return lists, nil
+}
+
+// parseCueList decodes the cue entries of a PCOB or PCO2 tag. Each entry is a
+// tag of its own, PCPT for PCOB tags and PCP2 for PCO2 tags.
+func parseCueList(t *Tag) (*CueList, error) {
+	list := &CueList{
+		Type:     CueListType(t.u32(0x0c)),
+		Extended: t.Kind == TagCueListExt,
+		Cues:     []Cue{},
+	}
+
+	count := int(t.u16(0x12))
+	if list.Extended {
+		count = int(t.u16(0x10))
+	}
+
+	data := t.Data
+
+	for i := 0; i < count; i++ {
+		entry, size, err := ParseTag(data)
+		if err != nil {
+			return nil, err
+		}
+
+		var cue Cue
+		if list.Extended {
+			cue, err = parseCueExt(entry)
+		} else {
+			cue, err = parseCue(entry)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		list.Cues = append(list.Cues, cue)
+		data = data[size:]
+	}
+
+	return list, nil
+}
+
+// cueMinSize is the number of bytes of PCPT cue entries which are decoded.
+const cueMinSize = 0x28
+
+// parseCue decodes a PCPT cue entry.
+func parseCue(entry *Tag) (Cue, error) {
+	data := entry.raw
+
+	if entry.Kind != "PCPT" || len(data) < cueMinSize {
+		return Cue{}, malformed("invalid %q cue entry", entry.Kind)
+	}
+
+	cue := Cue{
+		HotCue: binary.BigEndian.Uint32(data[0x0c:]),
+		Loop:   data[0x1c] == cueKindLoop,
+		Time:   time.Duration(binary.BigEndian.Uint32(data[0x20:])) * time.Millisecond,
+	}
+
+	if loop := binary.BigEndian.Uint32(data[0x24:]); loop != noLoop {
+		cue.LoopTime = time.Duration(loop) * time.Millisecond
+	}
+
+	return cue, nil
+}
+
+// Layout of PCP2 cue entries.
+const (
+	cueExtCommentSize = 0x28
+	cueExtComment     = 0x2c
+	cueExtMinSize     = 0x2c
+)
+
+// parseCueExt decodes a PCP2 cue entry.
+func parseCueExt(entry *Tag) (Cue, error) {
+	data := entry.raw
+
+	if entry.Kind != "PCP2" || len(data) < cueExtMinSize {
+		return Cue{}, malformed("invalid %q cue entry", entry.Kind)
+	}
+
+	cue := Cue{
+		HotCue:  binary.BigEndian.Uint32(data[0x0c:]),
+		Loop:    data[0x10] == cueKindLoop,
+		Time:    time.Duration(binary.BigEndian.Uint32(data[0x14:])) * time.Millisecond,
+		ColorID: data[0x1c],
+	}
+
+	if loop := binary.BigEndian.Uint32(data[0x18:]); loop != noLoop {
+		cue.LoopTime = time.Duration(loop) * time.Millisecond
+	}
+
+	commentSize := int(binary.BigEndian.Uint32(data[cueExtCommentSize:]))
+	if cueExtComment+commentSize > len(data) {
+		return Cue{}, malformed("cue comment is too long (%d bytes)", commentSize)
+	}
+
+	cue.Comment = decodeUTF16(data[cueExtComment : cueExtComment+commentSize])
+
+	// The color of the cue follows the comment, starting with the index of
+	// the color within the hot cue palette
+	if rgb := data[cueExtComment+commentSize:]; len(rgb) >= 4 && rgb[0] != 0 {
+		cue.HasColor = true
+		cue.Red, cue.Green, cue.Blue = rgb[1], rgb[2], rgb[3]
+	}
+
+	return cue, nil
+}
+
+// Waveform is the waveform of a PWAV, PWV2 through PWV7 tag. The entries of
+// the waveform are not decoded, their format depends on the kind of tag:
+//
+//   - PWAV, PWV2, PWV3: one byte per segment, the lower 5 bits are the height
+//     and the upper 3 bits the whiteness of the segment.
+//   - PWV4: six bytes per segment, the last three being the intensity of the
+//     red, green and blue components of the segment.
+//   - PWV5: two bytes per segment, the 3 bit red, green and blue components
+//     followed by the 5 bit height of the segment.
+//   - PWV6, PWV7: three bytes per segment, the height of the mid, high and
+//     low frequency bands.
+type Waveform struct {
+	Kind      string
+	EntrySize int
+	Entries   []byte
+}
+
+// Len returns the number of segments of the waveform.
+func (w *Waveform) Len() int {
+	return len(w.Entries) / w.EntrySize
+}
+
+// Entry returns the entry of the segment at the index.
+func (w *Waveform) Entry(i int) []byte {
+	return w.Entries[i*w.EntrySize : (i+1)*w.EntrySize]
+}
+
+// Waveform decodes the waveform tag of the kind.
+func (f *File) Waveform(kind string) (*Waveform, error) {
+	t, err := f.find(kind)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseWaveform(t)
+}
+
+// ParseWaveform decodes a waveform tag, which may also be retrieved from the
+// remote database of a player.
+func ParseWaveform(t *Tag) (*Waveform, error) {
+	size, count := int(t.u32(0x0c)), int(t.u32(0x10))
+
+	switch t.Kind {
+	case TagWaveformPreview, TagWaveformTinyPreview:
+		size, count = 1, int(t.u32(0x0c))
+	case TagWaveformDetail, TagWaveformColorPreview, TagWaveformColorDetail,
+		TagWaveform3BandPreview, TagWaveform3BandDetail:
+	default:
+		return nil, malformed("%q is not a waveform tag", t.Kind)
+	}
+
+	if size == 0 || size*count > len(t.Data) {
+		return nil, malformed("waveform lists %d entries of %d bytes", count, size)
+	}
+
+	return &Waveform{Kind: t.Kind, EntrySize: size, Entries: t.Data[:size*count]}, nil
+}
+
+// Mood is the overall mood of a track, determining the kinds of phrases of
+// its song structure.
+type Mood uint16
+
+// Known moods.
+const (
+	MoodHigh Mood = 1
+	MoodMid  Mood = 2
+	MoodLow  Mood = 3
+)
+
+// phraseNames names the kinds of phrases for each mood.
+var phraseNames = map[Mood]map[uint16]string{
+	MoodHigh: {1: "Intro", 2: "Up", 3: "Down", 5: "Chorus", 6: "Outro"},
+	MoodMid: {
+		1: "Intro", 2: "Verse 1", 3: "Verse 2", 4: "Verse 3", 5: "Verse 4",
+		6: "Verse 5", 7: "Verse 6", 8: "Bridge", 9: "Chorus", 10: "Outro",
+	},
+	MoodLow: {
+		1: "Intro", 2: "Verse 1", 3: "Verse 1", 4: "Verse 1", 5: "Verse 2",
+		6: "Verse 2", 7: "Verse 2", 8: "Bridge", 9: "Chorus", 10: "Outro",
+	},
+}
+
+// Phrase is a single phrase of the song structure of a track.
+type Phrase struct {
+	Index uint16
+
+	// Beat is the number of the beat the phrase starts at, and EndBeat is
+	// the number of the beat the following phrase starts at.
+	Beat    uint16
+	EndBeat uint16
+
+	// Kind identifies the phrase within the mood of the track, Name is the
+	// name rekordbox shows for the kind.
+	Kind uint16
+	Name string
+
+	// Fill is set when the phrase ends in a fill in, starting at the
+	// FillBeat.
+	Fill     bool
+	FillBeat uint16
+}
+
+// SongStructure is the phrase analysis of a track, found in EXT files.
+type SongStructure struct {
+	Mood Mood
+
+	// EndBeat is the number of the beat the last phrase ends at.
+	EndBeat uint16
+
+	// Bank is the lighting bank assigned to the track.
+	Bank uint8
+
+	Phrases []Phrase
+}
+
+// Layout of the PSSI tag.
+const (
+	phraseEntrySize = 0x18
+	phraseMaskStart = 0x12
+)
+
+// phraseMask is XORed with the PSSI tags written by rekordbox 6 and later,
+// starting at the mood of the tag. Each byte of the mask is offset by the
+// number of phrases.
+var phraseMask = []byte{
+	0xcb, 0xe1, 0xee, 0xfa, 0xe5, 0xee, 0xad, 0xee, 0xe9, 0xd2,
+	0xe9, 0xeb, 0xe1, 0xe9, 0xf3, 0xe8, 0xe9, 0xf4, 0xe1,
+}
+
+// SongStructure decodes the song structure of the file.
+func (f *File) SongStructure() (*SongStructure, error) {
+	t, err := f.find(TagSongStructure)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(t.Header) < 0x20 {
+		return nil, malformed("song structure header is too short")
+	}
+
+	count := int(t.u16(0x10))
+	data := append([]byte{}, t.raw...)
+
+	if mood := Mood(binary.BigEndian.Uint16(data[phraseMaskStart:])); mood < MoodHigh || mood > MoodLow {
+		for i := phraseMaskStart; i < len(data); i++ {
+			data[i] ^= phraseMask[(i-phraseMaskStart)%len(phraseMask)] + byte(count)
+		}
+	}
+
+	entries := data[len(t.Header):]
+	if count*phraseEntrySize > len(entries) {
+		return nil, malformed("song structure lists %d phrases", count)
+	}
+
+	s := &SongStructure{
+		Mood:    Mood(binary.BigEndian.Uint16(data[0x12:])),
+		EndBeat: binary.BigEndian.Uint16(data[0x1a:]),
+		Bank:    data[0x1e],
+		Phrases: make([]Phrase, count),
+	}
+
+	for i := range s.Phrases {
+		entry := entries[i*phraseEntrySize:]
+
+		s.Phrases[i] = Phrase{
+			Index:    binary.BigEndian.Uint16(entry[0x00:]),
+			Beat:     binary.BigEndian.Uint16(entry[0x02:]),
+			Kind:     binary.BigEndian.Uint16(entry[0x04:]),
+			Fill:     entry[0x15] != 0,
+			FillBeat: binary.BigEndian.Uint16(entry[0x16:]),
+		}
+
+		s.Phrases[i].Name = phraseNames[s.Mood][s.Phrases[i].Kind]
+
+		if i > 0 {
+			s.Phrases[i-1].EndBeat = s.Phrases[i].Beat
+		}
+	}
+
+	if count > 0 {
+		s.Phrases[count-1].EndBeat = s.EndBeat
+	}
+
+	return s, nil
+}
@@ -0,0 +1,49 @@
+package prolink
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+
+	// Artwork is served as JPEG or PNG images
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// ErrNoArtwork is returned by DecodeArtwork when the track has no artwork.
+var ErrNoArtwork = fmt.Errorf("The track has no artwork")
+
+// Artwork is the decoded album artwork of a track.
+type Artwork struct {
+	Image image.Image
+
+	// Format is the name of the image format the artwork was encoded in,
+	// such as "jpeg" or "png".
+	Format string
+
+	Width  int
+	Height int
+}
+
+// DecodeArtwork decodes the artwork of the track.
+func (t *Track) DecodeArtwork() (*Artwork, error) {
+	if len(t.Artwork) == 0 {
+		return nil, ErrNoArtwork
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(t.Artwork))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode artwork: %s", err)
+	}
+
+	bounds := img.Bounds()
+
+	artwork := &Artwork{
+		Image:  img,
+		Format: format,
+		Width:  bounds.Dx(),
+		Height: bounds.Dy(),
+	}
+
+	return artwork, nil
+}
@@ -0,0 +1,199 @@
+package prolink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// Length of beat packets
+const beatPacketLen = 0x60
+
+// Packet type byte of beat packets
+const beatType byte = 0x28
+
+// Beat represents a beat packet broadcast by a device on every beat of the
+// playing track.
+//
+// The beat timings report how long until the upcoming beats and bars will
+// occur, assuming the track continues playing at the current tempo.
+type Beat struct {
+	PlayerID       DeviceID
+	TrackBPM       float32
+	EffectivePitch float32
+	BeatInMeasure  uint8
+
+	NextBeat   time.Duration
+	SecondBeat time.Duration
+	NextBar    time.Duration
+	FourthBeat time.Duration
+	SecondBar  time.Duration
+	EighthBeat time.Duration
+}
+
+// String returns a string representation of the beat.
+func (b *Beat) String() string {
+	beatText := `Beat of Device %d
+  BPM    %-9s [effective pitch %2.2f%%]
+  Beat   %-9s [next beat %s, next bar %s]`
+
+	return fmt.Sprintf(beatText,
+		b.PlayerID,
+		fmt.Sprintf("%2.2f", b.TrackBPM),
+		b.EffectivePitch,
+		fmt.Sprintf("%d/4", b.BeatInMeasure),
+		b.NextBeat,
+		b.NextBar,
+	)
+}
+
+// beatTiming converts a uint32 millisecond value into a duration.
+func beatTiming(p []byte) time.Duration {
+	return time.Duration(binary.BigEndian.Uint32(p)) * time.Millisecond
+}
+
+func packetToBeat(p []byte) (*Beat, error) {
+	if !bytes.HasPrefix(p, prolinkHeader) {
+		return nil, fmt.Errorf("Beat packet does not start with the expected header")
+	}
+
+	if len(p) <= 0x0A || p[0x0A] != beatType {
+		return nil, nil
+	}
+
+	if len(p) < beatPacketLen {
+		return nil, fmt.Errorf("Beat packet is too short (%d bytes)", len(p))
+	}
+
+	beat := &Beat{
+		PlayerID:       DeviceID(p[0x21]),
+		TrackBPM:       calcBPM(p[0x5A : 0x5A+2]),
+		EffectivePitch: calcPitch(p[0x55 : 0x55+3]),
+		BeatInMeasure:  uint8(p[0x5C]),
+		NextBeat:       beatTiming(p[0x24 : 0x24+4]),
+		SecondBeat:     beatTiming(p[0x28 : 0x28+4]),
+		NextBar:        beatTiming(p[0x2C : 0x2C+4]),
+		FourthBeat:     beatTiming(p[0x30 : 0x30+4]),
+		SecondBar:      beatTiming(p[0x34 : 0x34+4]),
+		EighthBeat:     beatTiming(p[0x38 : 0x38+4]),
+	}
+
+	return beat, nil
+}
+
+// MarshalBinary encodes the beat as a beat packet, in the same form players
+// report beats on the network.
+func (b *Beat) MarshalBinary() ([]byte, error) {
+	p := make([]byte, beatPacketLen)
+
+	copy(p, prolinkHeader)
+	p[0x0A] = beatType
+	p[0x20] = 0x01
+	p[0x21] = byte(b.PlayerID)
+	binary.BigEndian.PutUint16(p[0x22:], beatPacketLen-0x24)
+
+	timings := []time.Duration{
+		b.NextBeat, b.SecondBeat, b.NextBar,
+		b.FourthBeat, b.SecondBar, b.EighthBeat,
+	}
+
+	for i, timing := range timings {
+		ms := uint32(timing / time.Millisecond)
+		binary.BigEndian.PutUint32(p[0x24+i*4:], ms)
+	}
+
+	copy(p[0x55:], pitchBytes(b.EffectivePitch))
+	copy(p[0x5A:], bpmBytes(b.TrackBPM))
+	p[0x5C] = b.BeatInMeasure
+	p[0x5F] = byte(b.PlayerID)
+
+	return p, nil
+}
+
+// A BeatHandler responds to beats reported by a device.
+type BeatHandler interface {
+	OnBeat(*Beat)
+}
+
+// The BeatHandlerFunc is an adapter to allow a function to be used as a
+// BeatHandler.
+type BeatHandlerFunc func(*Beat)
+
+// OnBeat implements BeatHandler.
+func (f BeatHandlerFunc) OnBeat(b *Beat) { f(b) }
+
+// BeatMonitor provides an interface for watching for beats reported by devices
+// on the PRO DJ LINK network.
+type BeatMonitor struct {
+	handlers *handlerSet
+	done     chan bool
+}
+
+// OnBeat registers a BeatHandler to be called when any device on the PRO DJ
+// LINK network reports a beat. Beats are delivered to the handler in the order
+// they were received.
+func (bm *BeatMonitor) OnBeat(h BeatHandler) *Subscription {
+	return bm.handlers.add(h)
+}
+
+// activate triggers the BeatMonitor to begin listening for beat packets given
+// a UDP connection to listen on. Errors reading or parsing packets are given
+// to the errorReporter.
+func (bm *BeatMonitor) activate(listenConn PacketListener, reportError errorReporter) {
+	packet := make([]byte, 512)
+
+	beatHandler := func() {
+		n, addr, err := listenConn.ReadFrom(packet)
+		if err != nil {
+			select {
+			case <-bm.done:
+			default:
+				reportError(nil, nil, err)
+			}
+
+			return
+		}
+
+		if n == 0 {
+			return
+		}
+
+		beat, err := packetToBeat(packet[:n])
+		if err != nil {
+			reportError(addr, packet[:n], err)
+			return
+		}
+
+		if beat == nil {
+			return
+		}
+
+		bm.handlers.notify(func(h interface{}) { h.(BeatHandler).OnBeat(beat) })
+	}
+
+	go func() {
+		for {
+			select {
+			case <-bm.done:
+				return
+			default:
+				beatHandler()
+			}
+		}
+	}()
+}
+
+// deactivate stops the BeatMonitor from listening for beat packets. The
+// listening connection must be closed after deactivating to unblock any
+// pending reads.
+func (bm *BeatMonitor) deactivate() {
+	close(bm.done)
+}
+
+func newBeatMonitor() *BeatMonitor {
+	return &BeatMonitor{
+		handlers: &handlerSet{},
+		done:     make(chan bool),
+	}
+}
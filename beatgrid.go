@@ -0,0 +1,111 @@
+package prolink
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"go.evanpurkhiser.com/prolink/dbserver"
+)
+
+// beatGridHeaderSize is the number of bytes preceding the beats of a beat grid.
+const beatGridHeaderSize = 0x14
+
+// beatGridEntrySize is the number of bytes used to describe each beat.
+const beatGridEntrySize = 0x10
+
+// GridBeat is a single beat of a tracks beat grid.
+type GridBeat struct {
+	// Time is the position of the beat from the start of the track, with
+	// millisecond precision.
+	Time time.Duration
+
+	// BeatInMeasure is the position of the beat within its bar, from 1 to 4.
+	BeatInMeasure uint8
+
+	// BPM is the tempo of the track at the beat.
+	BPM float32
+}
+
+// BeatGrid describes the position of every beat of a track, as analyzed by
+// rekordbox.
+type BeatGrid struct {
+	Beats []GridBeat
+}
+
+// BeatTime returns the position in the track of the beat number, as reported
+// by CDJStatus.Beat. Beat numbers start at 1. False is returned if the beat is
+// not part of the grid.
+func (g *BeatGrid) BeatTime(beat uint32) (time.Duration, bool) {
+	if beat == 0 || int(beat) > len(g.Beats) {
+		return 0, false
+	}
+
+	return g.Beats[beat-1].Time, true
+}
+
+// BeatAt returns the number of the beat at or before the position of the
+// track, as reported by CDJStatus.Beat. Zero is returned when the position
+// is before the first beat.
+func (g *BeatGrid) BeatAt(position time.Duration) uint32 {
+	var beat uint32
+
+	for i, b := range g.Beats {
+		if b.Time > position {
+			break
+		}
+
+		beat = uint32(i + 1)
+	}
+
+	return beat
+}
+
+// MarshalBinary encodes the beat grid in the form served by the remote
+// database.
+func (g *BeatGrid) MarshalBinary() ([]byte, error) {
+	data := make([]byte, beatGridHeaderSize+len(g.Beats)*beatGridEntrySize)
+
+	for i, b := range g.Beats {
+		entry := data[beatGridHeaderSize+i*beatGridEntrySize:]
+
+		binary.LittleEndian.PutUint16(entry[0x00:], uint16(b.BeatInMeasure))
+		binary.LittleEndian.PutUint16(entry[0x02:], uint16(b.BPM*100+0.5))
+		binary.LittleEndian.PutUint32(entry[0x04:], uint32(b.Time/time.Millisecond))
+	}
+
+	return data, nil
+}
+
+// parseBeatGrid decodes the beat grid data served by the remote database.
+func parseBeatGrid(data []byte) (*BeatGrid, error) {
+	if len(data) < beatGridHeaderSize {
+		return nil, fmt.Errorf("Beat grid is too short (%d bytes)", len(data))
+	}
+
+	count := (len(data) - beatGridHeaderSize) / beatGridEntrySize
+	grid := &BeatGrid{Beats: make([]GridBeat, count)}
+
+	for i := range grid.Beats {
+		entry := data[beatGridHeaderSize+i*beatGridEntrySize:]
+
+		grid.Beats[i] = GridBeat{
+			BeatInMeasure: uint8(binary.LittleEndian.Uint16(entry[0x00:])),
+			BPM:           float32(binary.LittleEndian.Uint16(entry[0x02:])) / 100,
+			Time:          time.Duration(binary.LittleEndian.Uint32(entry[0x04:])) * time.Millisecond,
+		}
+	}
+
+	return grid, nil
+}
+
+// GetBeatGrid queries the remote database for the beat grid of the track.
+// ErrUnavailable is returned when the track has not been analyzed.
+func (rd *RemoteDB) GetBeatGrid(q *TrackQuery) (*BeatGrid, error) {
+	data, err := rd.dataRequest(q, dbserver.MsgBeatGrid, dbserver.MsgBeatGridData, dbserver.Uint32(q.TrackID))
+	if err != nil {
+		return nil, err
+	}
+
+	return parseBeatGrid(data)
+}
@@ -0,0 +1,99 @@
+package prolink
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseBeatGrid(t *testing.T) {
+	data := append(make([]byte, beatGridHeaderSize),
+		0x01, 0x00, 0x44, 0x2f, 0xe8, 0x03, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x02, 0x00, 0x44, 0x2f, 0xa3, 0x05, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		// A trailing partial beat is ignored
+		0x03, 0x00,
+	)
+
+	grid, err := parseBeatGrid(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []GridBeat{
+		{Time: 1000 * time.Millisecond, BeatInMeasure: 1, BPM: 121},
+		{Time: 1443 * time.Millisecond, BeatInMeasure: 2, BPM: 121},
+	}
+
+	if !reflect.DeepEqual(grid.Beats, want) {
+		t.Errorf("got %+v, want %+v", grid.Beats, want)
+	}
+
+	if _, err := parseBeatGrid(data[:beatGridHeaderSize-1]); err == nil {
+		t.Error("expected an error for a short header")
+	}
+}
+
+func TestBeatGridMarshalBinary(t *testing.T) {
+	grid := &BeatGrid{Beats: []GridBeat{
+		{Time: 250 * time.Millisecond, BeatInMeasure: 1, BPM: 128},
+		{Time: 718 * time.Millisecond, BeatInMeasure: 2, BPM: 128.5},
+	}}
+
+	data, err := grid.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := parseBeatGrid(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, grid) {
+		t.Errorf("got %+v, want %+v", got, grid)
+	}
+}
+
+func TestBeatGridBeatTime(t *testing.T) {
+	grid := &BeatGrid{Beats: []GridBeat{
+		{Time: 100 * time.Millisecond},
+		{Time: 600 * time.Millisecond},
+		{Time: 1100 * time.Millisecond},
+	}}
+
+	tests := []struct {
+		beat   uint32
+		want   time.Duration
+		wantOK bool
+	}{
+		{beat: 0, wantOK: false},
+		{beat: 1, want: 100 * time.Millisecond, wantOK: true},
+		{beat: 3, want: 1100 * time.Millisecond, wantOK: true},
+		{beat: 4, wantOK: false},
+	}
+
+	for _, tt := range tests {
+		got, ok := grid.BeatTime(tt.beat)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("BeatTime(%d) = %s, %t, want %s, %t", tt.beat, got, ok, tt.want, tt.wantOK)
+		}
+	}
+
+	positions := []struct {
+		position time.Duration
+		want     uint32
+	}{
+		{position: 0, want: 0},
+		{position: 100 * time.Millisecond, want: 1},
+		{position: 599 * time.Millisecond, want: 1},
+		{position: 5 * time.Second, want: 3},
+	}
+
+	for _, tt := range positions {
+		if got := grid.BeatAt(tt.position); got != tt.want {
+			t.Errorf("BeatAt(%s) = %d, want %d", tt.position, got, tt.want)
+		}
+	}
+}
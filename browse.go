@@ -0,0 +1,186 @@
+package prolink
+
+import (
+	"go.evanpurkhiser.com/prolink/dbserver"
+)
+
+// BrowseQuery identifies the media to browse the menus of, and the page of
+// the menu to render.
+type BrowseQuery struct {
+	DeviceID DeviceID
+	Slot     TrackSlot
+
+	// Offset is the index of the first item to render. Limit is the number of
+	// items to render, when zero all items after the offset are rendered.
+	Offset uint32
+	Limit  uint32
+}
+
+// MenuItem is an item of a menu browsed using the remote database.
+type MenuItem struct {
+	// ID identifies the item within its kind. For example the ID of a track
+	// item may be used as the TrackID of a TrackQuery, and the ID of a
+	// playlist item may be given to PlaylistTracks.
+	ID uint32
+
+	// Type is the kind of the item. Items listing tracks include the type of
+	// the secondary label in the second byte, use Type.Base to ignore it.
+	Type dbserver.ItemType
+
+	// Label is the primary text of the item, Label2 is secondary text such as
+	// the artist of a track, which may be empty.
+	Label  string
+	Label2 string
+
+	ArtworkID uint32
+}
+
+// Menu is a page of a menu browsed using the remote database.
+type Menu struct {
+	Items []*MenuItem
+
+	// Total is the number of items in the menu, which may be more than the
+	// number of items rendered.
+	Total uint32
+}
+
+// browse prepares the menu and renders the page selected by the query.
+func (rd *RemoteDB) browse(q *BrowseQuery, kind dbserver.MessageType, args ...dbserver.Field) (*Menu, error) {
+	if !rd.IsLinked(q.DeviceID) {
+		return nil, ErrDeviceNotLinked
+	}
+
+	menu, err := rd.executeBrowse(q, kind, args...)
+	rd.refreshOnError(q.DeviceID, err)
+
+	return menu, err
+}
+
+func (rd *RemoteDB) executeBrowse(q *BrowseQuery, kind dbserver.MessageType, args ...dbserver.Field) (*Menu, error) {
+	devConn := rd.getConnection(q.DeviceID)
+	if devConn == nil {
+		return nil, ErrDeviceNotLinked
+	}
+
+	devConn.lock.Lock()
+	defer devConn.lock.Unlock()
+
+	dmst := dbserver.DMST(byte(rd.getDeviceID()), dbserver.MenuMain, byte(q.Slot), slotTrackType(q.Slot))
+
+	total, err := rd.prepareMenu(q.DeviceID, dmst, kind, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	menu := &Menu{Total: total, Items: []*MenuItem{}}
+
+	if q.Offset >= total {
+		return menu, nil
+	}
+
+	count := total - q.Offset
+	if q.Limit != 0 && q.Limit < count {
+		count = q.Limit
+	}
+
+	items, err := rd.renderMenu(q.DeviceID, dmst, q.Offset, count, total)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range items {
+		menu.Items = append(menu.Items, &MenuItem{
+			ID:        item.ID,
+			Type:      item.Type,
+			Label:     item.Label,
+			Label2:    item.Label2,
+			ArtworkID: item.ArtworkID,
+		})
+	}
+
+	return menu, nil
+}
+
+// sortDefault requests menus be sorted in the default order of the player.
+const sortDefault = dbserver.Uint32(0)
+
+// RootMenu lists the top level menus available on the media, such as the
+// artist, album and playlist menus.
+func (rd *RemoteDB) RootMenu(q *BrowseQuery) (*Menu, error) {
+	return rd.browse(q, dbserver.MsgRootMenu, sortDefault, dbserver.Uint32(0x00ffffff))
+}
+
+// Playlists lists the playlists and playlist folders within the folder. The
+// top level playlists are listed using a folder ID of zero.
+func (rd *RemoteDB) Playlists(q *BrowseQuery, folderID uint32) (*Menu, error) {
+	return rd.browse(q, dbserver.MsgPlaylist, sortDefault, dbserver.Uint32(folderID), dbserver.Uint32(1))
+}
+
+// PlaylistTracks lists the tracks in the playlist.
+func (rd *RemoteDB) PlaylistTracks(q *BrowseQuery, playlistID uint32) (*Menu, error) {
+	return rd.browse(q, dbserver.MsgPlaylist, sortDefault, dbserver.Uint32(playlistID), dbserver.Uint32(0))
+}
+
+// Tracks lists every track on the media. The tracks of a CD are listed using
+// the TrackSlotCD slot, the ID of each track item is its track number.
+func (rd *RemoteDB) Tracks(q *BrowseQuery) (*Menu, error) {
+	return rd.browse(q, dbserver.MsgTrackMenu, sortDefault)
+}
+
+// Artists lists the artists of tracks on the media.
+func (rd *RemoteDB) Artists(q *BrowseQuery) (*Menu, error) {
+	return rd.browse(q, dbserver.MsgArtistMenu, sortDefault)
+}
+
+// ArtistAlbums lists the albums of the artist.
+func (rd *RemoteDB) ArtistAlbums(q *BrowseQuery, artistID uint32) (*Menu, error) {
+	return rd.browse(q, dbserver.MsgArtistAlbumMenu, sortDefault, dbserver.Uint32(artistID))
+}
+
+// Albums lists the albums of tracks on the media.
+func (rd *RemoteDB) Albums(q *BrowseQuery) (*Menu, error) {
+	return rd.browse(q, dbserver.MsgAlbumMenu, sortDefault)
+}
+
+// AlbumTracks lists the tracks of the album.
+func (rd *RemoteDB) AlbumTracks(q *BrowseQuery, albumID uint32) (*Menu, error) {
+	return rd.browse(q, dbserver.MsgAlbumTrackMenu, sortDefault, dbserver.Uint32(albumID))
+}
+
+// Genres lists the genres of tracks on the media.
+func (rd *RemoteDB) Genres(q *BrowseQuery) (*Menu, error) {
+	return rd.browse(q, dbserver.MsgGenreMenu, sortDefault)
+}
+
+// GenreArtists lists the artists of tracks in the genre.
+func (rd *RemoteDB) GenreArtists(q *BrowseQuery, genreID uint32) (*Menu, error) {
+	return rd.browse(q, dbserver.MsgGenreArtistMenu, sortDefault, dbserver.Uint32(genreID))
+}
+
+// Keys lists the musical keys of tracks on the media.
+func (rd *RemoteDB) Keys(q *BrowseQuery) (*Menu, error) {
+	return rd.browse(q, dbserver.MsgKeyMenu, sortDefault)
+}
+
+// BPMs lists the tempos of tracks on the media. The ID of each item is the
+// tempo multiplied by 100.
+func (rd *RemoteDB) BPMs(q *BrowseQuery) (*Menu, error) {
+	return rd.browse(q, dbserver.MsgBPMMenu, sortDefault)
+}
+
+// BPMRangeTracks lists the tracks with a tempo within the percentage range of
+// the tempo, given as an ID of a BPMs item.
+func (rd *RemoteDB) BPMRangeTracks(q *BrowseQuery, tempoID uint32, percent uint32) (*Menu, error) {
+	return rd.browse(q, dbserver.MsgBPMRangeTrackMenu, sortDefault, dbserver.Uint32(tempoID), dbserver.Uint32(percent))
+}
+
+// History lists the history playlists of the media, recording the tracks
+// played in each session.
+func (rd *RemoteDB) History(q *BrowseQuery) (*Menu, error) {
+	return rd.browse(q, dbserver.MsgHistoryMenu, sortDefault)
+}
+
+// HistoryTracks lists the tracks of the history playlist.
+func (rd *RemoteDB) HistoryTracks(q *BrowseQuery, historyID uint32) (*Menu, error) {
+	return rd.browse(q, dbserver.MsgHistoryTrackMenu, sortDefault, dbserver.Uint32(historyID))
+}
@@ -0,0 +1,31 @@
+package prolink
+
+// GetCDTracks lists the tracks of the CD inserted in the player, including the
+// metadata of each track. Tracks are identified by their track number, which
+// may be used as the TrackID of a TrackQuery for the TrackSlotCD slot.
+//
+// The title and artist of tracks are only known for CDs with CD-TEXT, the
+// number and length of the track are always known.
+func (rd *RemoteDB) GetCDTracks(devID DeviceID) ([]*Track, error) {
+	menu, err := rd.Tracks(&BrowseQuery{DeviceID: devID, Slot: TrackSlotCD})
+	if err != nil {
+		return nil, err
+	}
+
+	tracks := make([]*Track, 0, len(menu.Items))
+
+	for _, item := range menu.Items {
+		track, err := rd.GetTrack(&TrackQuery{
+			DeviceID: devID,
+			Slot:     TrackSlotCD,
+			TrackID:  item.ID,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		tracks = append(tracks, track)
+	}
+
+	return tracks, nil
+}
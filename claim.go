@@ -0,0 +1,356 @@
+package prolink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// We wait 300 milliseconds between each device ID claim packet, as real
+// players do when joining the network.
+const claimInterval = 300 * time.Millisecond
+
+// Number of packets sent for each stage of claiming a device ID.
+const claimPacketCount = 3
+
+// How long to listen for devices already on the network before automatically
+// choosing a device ID.
+const claimListenDuration = 2 * keepAliveInterval
+
+// How long to listen for a device already using a configured device ID. Every
+// device is announced at least once within this duration, with the claim
+// interval allowing for late keep alive packets.
+const confirmListenDuration = keepAliveInterval + claimInterval
+
+// Packet types sent on the announce port.
+const (
+	claimStage1Type byte = 0x00
+	claimStage2Type byte = 0x02
+	claimStage3Type byte = 0x04
+	announceType    byte = 0x06
+	idInUseType     byte = 0x08
+)
+
+// autoDeviceIDs lists the device IDs that may be automatically claimed, in
+// order of preference. IDs 1-4 are preferred as only these devices may
+// retrieve track metadata from USB devices.
+var autoDeviceIDs = []DeviceID{
+	0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+	0x09, 0x0A, 0x0B, 0x0C, 0x0D, 0x0E, 0x0F,
+}
+
+// ErrDeviceIDInUse is returned when connecting to the network if the
+// configured virtual CDJ device ID is already in use by another device.
+var ErrDeviceIDInUse = fmt.Errorf("The device ID is already in use by another device")
+
+// ErrNoDeviceIDAvailable is returned when connecting to the network if no
+// device ID could be automatically claimed for the virtual CDJ.
+var ErrNoDeviceIDAvailable = fmt.Errorf("No device ID is available to claim")
+
+// getClaimPacketHeader constructs the common header of device ID claim
+// packets, given the packet type and full length of the packet.
+func getClaimPacketHeader(dev *Device, packetType byte, length uint16) []byte {
+	// The name is a 20 byte string
+	name := make([]byte, 20)
+	copy(name[:], []byte(dev.Name))
+
+	packetLen := make([]byte, 2)
+	binary.BigEndian.PutUint16(packetLen, length)
+
+	parts := [][]byte{
+		prolinkHeader,            // 0x00: 10 byte header
+		[]byte{packetType, 0x00}, // 0x0A: 02 byte packet type
+		name,                     // 0x0C: 20 byte device name
+		[]byte{0x01, 0x02},       // 0x20: 02 byte unknown
+		packetLen,                // 0x22: 02 byte packet length
+	}
+
+	return bytes.Join(parts, nil)
+}
+
+// getClaimStage1Packet constructs the first stage packet sent when claiming a
+// device ID. This announces the devices MAC address.
+func getClaimStage1Packet(dev *Device, count byte) []byte {
+	parts := [][]byte{
+		getClaimPacketHeader(dev, claimStage1Type, 0x2C),
+		[]byte{count},   // 0x24: 01 byte packet counter
+		[]byte{0x01},    // 0x25: 01 byte unknown
+		dev.MacAddr[:6], // 0x26: 06 byte mac address
+	}
+
+	return bytes.Join(parts, nil)
+}
+
+// getClaimStage2Packet constructs the second stage packet sent when claiming
+// a device ID. This announces the IP address and the device ID being claimed.
+func getClaimStage2Packet(dev *Device, count byte, auto bool) []byte {
+	// Real players report if the device ID was automatically assigned
+	assignMode := byte(0x02)
+	if auto {
+		assignMode = 0x01
+	}
+
+	parts := [][]byte{
+		getClaimPacketHeader(dev, claimStage2Type, 0x32),
+		dev.IP.To4(),         // 0x24: 04 byte IP address
+		dev.MacAddr[:6],      // 0x28: 06 byte mac address
+		[]byte{byte(dev.ID)}, // 0x2E: 01 byte device ID being claimed
+		[]byte{count},        // 0x2F: 01 byte packet counter
+		[]byte{0x01},         // 0x30: 01 byte unknown
+		[]byte{assignMode},   // 0x31: 01 byte auto assigned flag
+	}
+
+	return bytes.Join(parts, nil)
+}
+
+// getClaimStage3Packet constructs the final stage packet sent when claiming a
+// device ID.
+func getClaimStage3Packet(dev *Device, count byte) []byte {
+	parts := [][]byte{
+		getClaimPacketHeader(dev, claimStage3Type, 0x26),
+		[]byte{byte(dev.ID)}, // 0x24: 01 byte device ID being claimed
+		[]byte{count},        // 0x25: 01 byte packet counter
+	}
+
+	return bytes.Join(parts, nil)
+}
+
+// getIDInUsePacket constructs the packet sent to a device attempting to claim
+// the device ID of the given device, defending the device ID.
+func getIDInUsePacket(dev *Device) []byte {
+	parts := [][]byte{
+		getClaimPacketHeader(dev, idInUseType, 0x29),
+		[]byte{byte(dev.ID)}, // 0x24: 01 byte device ID in use
+		dev.IP.To4(),         // 0x25: 04 byte IP address
+	}
+
+	return bytes.Join(parts, nil)
+}
+
+// claimedDeviceID determines the device ID a second or final stage claim
+// packet is claiming. Second stage packets also include the IP and MAC address
+// of the claiming device, which are nil for final stage packets.
+func claimedDeviceID(p []byte) (id DeviceID, ip net.IP, mac net.HardwareAddr, ok bool) {
+	if !bytes.HasPrefix(p, prolinkHeader) || len(p) <= 0x0A {
+		return 0, nil, nil, false
+	}
+
+	switch {
+	case p[0x0A] == claimStage2Type && len(p) >= 0x32:
+		return DeviceID(p[0x2E]), net.IP(p[0x24:0x28]), net.HardwareAddr(p[0x28:0x2E]), true
+	case p[0x0A] == claimStage3Type && len(p) >= 0x26:
+		return DeviceID(p[0x24]), nil, nil, true
+	}
+
+	return 0, nil, nil, false
+}
+
+// readAnnouncePackets reads packets from the announce connection until the
+// duration has elapsed, calling fn for each packet received.
+func readAnnouncePackets(conn net.PacketConn, d time.Duration, fn func([]byte)) error {
+	conn.SetReadDeadline(time.Now().Add(d))
+	defer conn.SetReadDeadline(time.Time{})
+
+	packet := make([]byte, 512)
+
+	for {
+		n, _, err := conn.ReadFrom(packet)
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		fn(packet[:n])
+	}
+}
+
+// deviceIDClaimer implements the device ID claiming sequence for the virtual
+// CDJ. It tracks device IDs in use on the network while claiming.
+//
+// Packets received on the announce port must be handed to watchPacket while
+// the claimer waits between sending claim packets.
+type deviceIDClaimer struct {
+	vCDJ *Device
+	conn net.PacketConn
+	auto bool
+
+	// wait is called between sending each claim packet.
+	wait func(time.Duration) error
+
+	lock     sync.Mutex
+	used     map[DeviceID]bool
+	defended bool
+}
+
+// watchPacket handles packets received on the announce port while claiming.
+func (c *deviceIDClaimer) watchPacket(p []byte) {
+	if !bytes.HasPrefix(p, prolinkHeader) || len(p) <= 0x24 {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	switch p[0x0A] {
+	case announceType:
+		dev, err := deviceFromAnnouncePacket(p)
+		if err != nil || bytes.Equal(dev.MacAddr, c.vCDJ.MacAddr) {
+			return
+		}
+
+		c.used[dev.ID] = true
+
+	case idInUseType:
+		if DeviceID(p[0x24]) == c.vCDJ.ID {
+			c.defended = true
+		}
+	}
+}
+
+// nextDeviceID chooses the next available device ID to attempt to claim.
+func (c *deviceIDClaimer) nextDeviceID() (DeviceID, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, id := range autoDeviceIDs {
+		if !c.used[id] {
+			return id, nil
+		}
+	}
+
+	return 0, ErrNoDeviceIDAvailable
+}
+
+// sendStage sends each packet of a claim stage, listening for any devices
+// defending the device ID in between. Returns false if the ID was defended.
+func (c *deviceIDClaimer) sendStage(getPacket func(count byte) []byte) (bool, error) {
+	broadcastAddr := getBroadcastAddress(c.vCDJ)
+
+	for count := byte(1); count <= claimPacketCount; count++ {
+		if _, err := c.conn.WriteTo(getPacket(count), broadcastAddr); err != nil {
+			return false, err
+		}
+
+		if err := c.wait(claimInterval); err != nil {
+			return false, err
+		}
+
+		c.lock.Lock()
+		defended := c.defended
+		c.lock.Unlock()
+
+		if defended {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// claim runs the claiming sequence until a device ID has been claimed.
+func (c *deviceIDClaimer) claim() error {
+	for {
+		if c.auto {
+			id, err := c.nextDeviceID()
+			if err != nil {
+				return err
+			}
+
+			c.vCDJ.ID = id
+		}
+
+		c.lock.Lock()
+		c.defended = false
+		c.lock.Unlock()
+
+		stages := []func(count byte) []byte{
+			func(count byte) []byte { return getClaimStage1Packet(c.vCDJ, count) },
+			func(count byte) []byte { return getClaimStage2Packet(c.vCDJ, count, c.auto) },
+			func(count byte) []byte { return getClaimStage3Packet(c.vCDJ, count) },
+		}
+
+		claimed := true
+
+		for _, stage := range stages {
+			ok, err := c.sendStage(stage)
+			if err != nil {
+				return err
+			}
+
+			if !ok {
+				claimed = false
+				break
+			}
+		}
+
+		if claimed {
+			return nil
+		}
+
+		if !c.auto {
+			return ErrDeviceIDInUse
+		}
+
+		c.lock.Lock()
+		c.used[c.vCDJ.ID] = true
+		c.lock.Unlock()
+	}
+}
+
+// confirm checks that a manually configured device ID is not in use. Only a
+// single final stage claim packet is sent, giving any device using the device
+// ID the chance to defend it, after which the keep alive packets of devices
+// already on the network are listened for.
+func (c *deviceIDClaimer) confirm() error {
+	_, err := c.conn.WriteTo(getClaimStage3Packet(c.vCDJ, 1), getBroadcastAddress(c.vCDJ))
+	if err != nil {
+		return err
+	}
+
+	if err := c.wait(confirmListenDuration); err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.defended || c.used[c.vCDJ.ID] {
+		return ErrDeviceIDInUse
+	}
+
+	return nil
+}
+
+// claimDeviceID claims the virtual CDJs device ID on the network, following
+// the same sequence real players use when joining the network. When auto is
+// enabled an unused device ID will be chosen and assigned to the virtual CDJ,
+// otherwise the configured device ID is only confirmed to not be in use.
+func claimDeviceID(vCDJ *Device, announceConn net.PacketConn, auto bool) error {
+	claimer := &deviceIDClaimer{
+		vCDJ: vCDJ,
+		conn: announceConn,
+		auto: auto,
+		used: map[DeviceID]bool{},
+	}
+
+	claimer.wait = func(d time.Duration) error {
+		return readAnnouncePackets(announceConn, d, claimer.watchPacket)
+	}
+
+	if !auto {
+		return claimer.confirm()
+	}
+
+	// Learn which devices are already on the network before choosing an ID
+	if err := claimer.wait(claimListenDuration); err != nil {
+		return err
+	}
+
+	return claimer.claim()
+}
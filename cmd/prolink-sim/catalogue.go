@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net"
+	"time"
+
+	"go.evanpurkhiser.com/prolink"
+	"go.evanpurkhiser.com/prolink/remotedbtest"
+)
+
+// catalogue is the collection of tracks served by simulated players. The same
+// tracks and artwork are given to the remote database server of each player.
+type catalogue struct {
+	tracks         map[uint32]*prolink.Track
+	highResArtwork map[uint32][]byte
+}
+
+func (c *catalogue) get(id uint32) *prolink.Track {
+	return c.tracks[id]
+}
+
+// Sizes of the generated low and high resolution artwork.
+const (
+	artworkSize        = 80
+	highResArtworkSize = 240
+)
+
+// generateArtwork renders a solid color square JPEG image.
+func generateArtwork(id uint32, size int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	fill := color.RGBA{uint8(id * 67), uint8(id * 131), uint8(id * 197), 0xFF}
+
+	for x := 0; x < size; x++ {
+		for y := 0; y < size; y++ {
+			img.Set(x, y, fill)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	jpeg.Encode(buf, img, nil)
+
+	return buf.Bytes()
+}
+
+func newCatalogue(count int) *catalogue {
+	genres := []string{"House", "Techno", "Disco", "Drum & Bass"}
+	keys := []string{"Am", "Em", "Bm", "F#m", "Dbm", "Abm"}
+
+	c := &catalogue{
+		tracks:         map[uint32]*prolink.Track{},
+		highResArtwork: map[uint32][]byte{},
+	}
+
+	for i := 1; i <= count; i++ {
+		id := uint32(i)
+		artist := fmt.Sprintf("Artist %d", (i-1)%10+1)
+		album := fmt.Sprintf("Album %d", (i-1)%20+1)
+		title := fmt.Sprintf("Track %d", i)
+
+		c.tracks[id] = &prolink.Track{
+			ID:        id,
+			Title:     title,
+			Artist:    artist,
+			Album:     album,
+			Genre:     genres[i%len(genres)],
+			Label:     fmt.Sprintf("Label %d", (i-1)%5+1),
+			Comment:   "Simulated track",
+			Key:       keys[i%len(keys)],
+			BPM:       float32(120 + i%10),
+			Rating:    uint8(i % 6),
+			Color:     prolink.TrackColor(i % 9),
+			Year:      uint16(2000 + i%20),
+			BitRate:   320,
+			DateAdded: time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, i),
+			Path:      fmt.Sprintf("/Contents/%s/%s/%s.mp3", artist, album, title),
+			Length:    time.Duration(180+i) * time.Second,
+			Artwork:   generateArtwork(id, artworkSize),
+		}
+
+		c.highResArtwork[id] = generateArtwork(id, highResArtworkSize)
+	}
+
+	return c
+}
+
+// startDBServer begins answering remote database queries for the catalogue on
+// the IP address, using the remotedbtest fake remote database server.
+func startDBServer(ip net.IP, deviceID prolink.DeviceID, tracks *catalogue) (*remotedbtest.Server, error) {
+	server := remotedbtest.NewServer(deviceID)
+
+	for _, t := range tracks.tracks {
+		server.AddTrack(t)
+		server.AddHighResArtwork(t.ID, tracks.highResArtwork[t.ID])
+	}
+
+	if err := server.Start(nil, ip); err != nil {
+		return nil, err
+	}
+
+	return server, nil
+}
@@ -0,0 +1,203 @@
+// Command prolink-sim simulates a PRO DJ LINK network of CDJs and a DJM mixer.
+//
+// Simulated devices announce themselves, report their status and beats, and
+// answer remote database queries for a catalogue of generated tracks. Player
+// actions such as loading, playing and going on air are driven by a script,
+// see parseScript for the script format.
+//
+// By default devices are simulated on the loopback interface, connect to the
+// simulated network using a Config.NetIface of "lo". Devices may instead be
+// simulated on a dummy interface by providing addresses assigned to the
+// interface using -ip.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"time"
+
+	"go.evanpurkhiser.com/prolink"
+)
+
+// Device ID the simulated mixer announces itself with.
+const mixerID prolink.DeviceID = 0x21
+
+// Number of tracks in the simulated catalogue.
+const catalogueSize = 100
+
+// broadcastAddress determines the broadcast address for the IP address using
+// its default mask.
+func broadcastAddress(ip net.IP) net.IP {
+	ip = ip.To4()
+	mask := ip.DefaultMask()
+	broadcast := make(net.IP, net.IPv4len)
+
+	for i := range ip {
+		broadcast[i] = ip[i] | ^mask[i]
+	}
+
+	return broadcast
+}
+
+// deviceIP returns the IP address offset from the base address.
+func deviceIP(base net.IP, offset int) net.IP {
+	ip := make(net.IP, net.IPv4len)
+	copy(ip, base.To4())
+	ip[3] += byte(offset)
+
+	return ip
+}
+
+// runScript applies the script events to the players, relative to the start
+// time. When loop is enabled the script is repeated.
+func runScript(events []*scriptEvent, players map[prolink.DeviceID]*player, loop bool, done <-chan bool) {
+	for {
+		start := time.Now()
+
+		for _, e := range events {
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Until(start.Add(e.At))):
+			}
+
+			p, ok := players[e.Player]
+			if !ok {
+				fmt.Printf("[!]: No player %d to %s\n", e.Player, e.Command)
+				continue
+			}
+
+			// Only one player may be the tempo master
+			if e.Command == "master" {
+				for _, other := range players {
+					other.setMaster(false)
+				}
+			}
+
+			if err := p.apply(e); err != nil {
+				fmt.Printf("[!]: Player %d %s: %s\n", e.Player, e.Command, err)
+				continue
+			}
+
+			fmt.Printf("[%s]: Player %d %s %v\n", e.At, e.Player, e.Command, e.Args)
+		}
+
+		if !loop || len(events) == 0 {
+			return
+		}
+	}
+}
+
+func main() {
+	baseIP := flag.String("ip", "127.0.0.10", "IP address of the first simulated device")
+	broadcastIP := flag.String("broadcast", "", "Broadcast address (defaults to the broadcast address of -ip)")
+	playerCount := flag.Int("players", 2, "Number of CDJs to simulate")
+	withMixer := flag.Bool("mixer", true, "Simulate a DJM mixer")
+	scriptPath := flag.String("script", "", "Script of player actions (defaults to mixing through each player)")
+	loop := flag.Bool("loop", false, "Repeat the script once finished")
+	flag.Parse()
+
+	base := net.ParseIP(*baseIP).To4()
+	if base == nil {
+		fmt.Printf("Invalid IP address %q\n", *baseIP)
+		os.Exit(1)
+	}
+
+	broadcast := broadcastAddress(base)
+	if *broadcastIP != "" {
+		if broadcast = net.ParseIP(*broadcastIP).To4(); broadcast == nil {
+			fmt.Printf("Invalid broadcast address %q\n", *broadcastIP)
+			os.Exit(1)
+		}
+	}
+
+	if *playerCount < 1 || *playerCount > 6 {
+		fmt.Println("Between 1 and 6 players may be simulated")
+		os.Exit(1)
+	}
+
+	events := defaultScript(*playerCount)
+
+	if *scriptPath != "" {
+		file, err := os.Open(*scriptPath)
+		if err != nil {
+			panic(err)
+		}
+
+		events, err = parseScript(file)
+		file.Close()
+
+		if err != nil {
+			fmt.Printf("Failed to parse script: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("-> Simulating PRO DJ LINK network (broadcasting to %s)\n", broadcast)
+
+	tracks := newCatalogue(catalogueSize)
+	players := map[prolink.DeviceID]*player{}
+	playerList := []*player{}
+	done := make(chan bool)
+
+	for i := 1; i <= *playerCount; i++ {
+		dev := &prolink.Device{
+			Name:    "CDJ-2000nexus",
+			ID:      prolink.DeviceID(i),
+			Type:    prolink.DeviceTypeCDJ,
+			MacAddr: net.HardwareAddr{0x02, 0x50, 0x4c, 0x00, 0x00, byte(i)},
+			IP:      deviceIP(base, i-1),
+		}
+
+		s, err := newSender(dev.IP, broadcast)
+		if err != nil {
+			panic(err)
+		}
+
+		if _, err := startDBServer(dev.IP, dev.ID, tracks); err != nil {
+			panic(err)
+		}
+
+		p := newPlayer(dev, s, tracks)
+		players[dev.ID] = p
+		playerList = append(playerList, p)
+
+		fmt.Printf("[+]: %s\n", dev)
+
+		go p.run(done)
+	}
+
+	if *withMixer {
+		dev := &prolink.Device{
+			Name:    "DJM-900nexus",
+			ID:      mixerID,
+			Type:    prolink.DeviceTypeMixer,
+			MacAddr: net.HardwareAddr{0x02, 0x50, 0x4c, 0x00, 0x00, byte(mixerID)},
+			IP:      deviceIP(base, *playerCount),
+		}
+
+		s, err := newSender(dev.IP, broadcast)
+		if err != nil {
+			panic(err)
+		}
+
+		m := &mixer{device: dev, sender: s, players: playerList}
+
+		fmt.Printf("[+]: %s\n", dev)
+
+		go m.run(done)
+	}
+
+	go runScript(events, players, *loop, done)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+
+	<-interrupt
+	close(done)
+
+	fmt.Println("-> Stopping simulated network")
+}
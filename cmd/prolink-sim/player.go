@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.evanpurkhiser.com/prolink"
+	"go.evanpurkhiser.com/prolink/bpm"
+)
+
+// How often devices announce themselves on the network.
+const keepAliveInterval = 1500 * time.Millisecond
+
+// How often devices report their status.
+const statusInterval = 200 * time.Millisecond
+
+// PRO DJ LINK ports packets are sent to.
+const (
+	announcePort = 50000
+	beatPort     = 50001
+	statusPort   = 50002
+)
+
+// sender broadcasts packets from a simulated device.
+type sender struct {
+	conn      *net.UDPConn
+	broadcast net.IP
+}
+
+// send broadcasts the encoded packet to the given port.
+func (s *sender) send(port int, m encoding.BinaryMarshaler) {
+	packet, err := m.MarshalBinary()
+	if err != nil {
+		fmt.Printf("[!]: Failed to encode packet: %s\n", err)
+		return
+	}
+
+	addr := &net.UDPAddr{IP: s.broadcast, Port: port}
+
+	if _, err := s.conn.WriteToUDP(packet, addr); err != nil {
+		fmt.Printf("[!]: Failed to send packet: %s\n", err)
+	}
+}
+
+func newSender(ip, broadcast net.IP) (*sender, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: ip})
+	if err != nil {
+		return nil, err
+	}
+
+	return &sender{conn: conn, broadcast: broadcast}, nil
+}
+
+// player simulates a CDJ, reporting its status and beats.
+type player struct {
+	device *prolink.Device
+	sender *sender
+	tracks *catalogue
+
+	lock   sync.Mutex
+	status prolink.CDJStatus
+}
+
+// apply performs a script command on the player.
+func (p *player) apply(e *scriptEvent) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	s := &p.status
+
+	switch e.Command {
+	case "load":
+		id, err := strconv.ParseUint(e.Args[0], 10, 32)
+		if err != nil {
+			return fmt.Errorf("Invalid track ID: %s", err)
+		}
+
+		track := p.tracks.get(uint32(id))
+		if track == nil {
+			return fmt.Errorf("Unknown track %d", id)
+		}
+
+		slot := prolink.TrackSlotUSB
+		if len(e.Args) > 1 {
+			var ok bool
+			if slot, ok = trackSlots[e.Args[1]]; !ok {
+				return fmt.Errorf("Unknown slot %q", e.Args[1])
+			}
+		}
+
+		s.TrackID = track.ID
+		s.TrackDevice = p.device.ID
+		s.TrackSlot = slot
+		s.TrackBPM = track.BPM
+		s.PlayState = prolink.PlayStateCued
+		s.Beat = 1
+		s.BeatInMeasure = 1
+	case "play":
+		s.PlayState = prolink.PlayStatePlaying
+	case "pause":
+		s.PlayState = prolink.PlayStatePaused
+	case "cue":
+		s.PlayState = prolink.PlayStateCued
+		s.Beat = 1
+		s.BeatInMeasure = 1
+	case "end":
+		s.PlayState = prolink.PlayStateEnded
+	case "eject":
+		s.TrackID = 0
+		s.TrackDevice = 0
+		s.TrackSlot = prolink.TrackSlotEmpty
+		s.TrackBPM = 0
+		s.PlayState = prolink.PlayStateEmpty
+		s.Beat = 0
+		s.BeatInMeasure = 0
+	case "onair":
+		s.IsOnAir = true
+	case "offair":
+		s.IsOnAir = false
+	case "master":
+		s.IsMaster = true
+	case "sync":
+		s.IsSync = true
+	case "unsync":
+		s.IsSync = false
+	case "pitch":
+		pitch, err := strconv.ParseFloat(e.Args[0], 32)
+		if err != nil {
+			return fmt.Errorf("Invalid pitch: %s", err)
+		}
+
+		s.SliderPitch = float32(pitch)
+		s.EffectivePitch = float32(pitch)
+	}
+
+	return nil
+}
+
+// setMaster updates if the player is the tempo master.
+func (p *player) setMaster(master bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.status.IsMaster = master
+}
+
+// currentStatus returns a copy of the players status.
+func (p *player) currentStatus() prolink.CDJStatus {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.status
+}
+
+// beatInterval reports the time until the next beat. Zero is reported if the
+// player is not playing.
+func (p *player) beatInterval() time.Duration {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	s := p.status
+
+	if s.PlayState != prolink.PlayStatePlaying || s.TrackBPM == 0 {
+		return 0
+	}
+
+	return bpm.ToDuration(s.TrackBPM, s.EffectivePitch)
+}
+
+// beat advances the player by a single beat, reporting the beat.
+func (p *player) beat(interval time.Duration) {
+	p.lock.Lock()
+	p.status.Beat++
+	p.status.BeatInMeasure = uint8((p.status.Beat-1)%4 + 1)
+
+	beat := &prolink.Beat{
+		PlayerID:       p.device.ID,
+		TrackBPM:       p.status.TrackBPM,
+		EffectivePitch: p.status.EffectivePitch,
+		BeatInMeasure:  p.status.BeatInMeasure,
+		NextBeat:       interval,
+		SecondBeat:     interval * 2,
+		NextBar:        interval * time.Duration(5-p.status.BeatInMeasure),
+		FourthBeat:     interval * 4,
+		SecondBar:      interval * time.Duration(9-p.status.BeatInMeasure),
+		EighthBeat:     interval * 8,
+	}
+	p.lock.Unlock()
+
+	p.sender.send(beatPort, beat)
+}
+
+// run reports the players status and beats until the done channel is closed.
+func (p *player) run(done <-chan bool) {
+	announce := time.NewTicker(keepAliveInterval)
+	status := time.NewTicker(statusInterval)
+	beat := time.NewTimer(statusInterval)
+
+	defer announce.Stop()
+	defer status.Stop()
+	defer beat.Stop()
+
+	p.sender.send(announcePort, p.device)
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-announce.C:
+			p.sender.send(announcePort, p.device)
+		case <-status.C:
+			p.lock.Lock()
+			p.status.PacketNum++
+			status := p.status
+			p.lock.Unlock()
+
+			p.sender.send(statusPort, &status)
+		case <-beat.C:
+			interval := p.beatInterval()
+			if interval == 0 {
+				beat.Reset(statusInterval)
+				continue
+			}
+
+			p.beat(interval)
+			beat.Reset(interval)
+		}
+	}
+}
+
+func newPlayer(dev *prolink.Device, s *sender, tracks *catalogue) *player {
+	return &player{
+		device: dev,
+		sender: s,
+		tracks: tracks,
+		status: prolink.CDJStatus{
+			PlayerID:  dev.ID,
+			PlayState: prolink.PlayStateEmpty,
+		},
+	}
+}
+
+// mixer simulates a DJM, reporting the tempo of the master player.
+type mixer struct {
+	device  *prolink.Device
+	sender  *sender
+	players []*player
+}
+
+// run reports the mixer status until the done channel is closed.
+func (m *mixer) run(done <-chan bool) {
+	announce := time.NewTicker(keepAliveInterval)
+	status := time.NewTicker(statusInterval)
+
+	defer announce.Stop()
+	defer status.Stop()
+
+	m.sender.send(announcePort, m.device)
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-announce.C:
+			m.sender.send(announcePort, m.device)
+		case <-status.C:
+			mixerStatus := &prolink.MixerStatus{DeviceID: m.device.ID}
+
+			for _, p := range m.players {
+				s := p.currentStatus()
+				if !s.IsMaster {
+					continue
+				}
+
+				mixerStatus.BPM = s.TrackBPM
+				mixerStatus.Pitch = s.EffectivePitch
+				mixerStatus.BeatInMeasure = s.BeatInMeasure
+			}
+
+			m.sender.send(statusPort, mixerStatus)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.evanpurkhiser.com/prolink"
+)
+
+// scriptEvent is a single action performed on a simulated player.
+type scriptEvent struct {
+	At      time.Duration
+	Player  prolink.DeviceID
+	Command string
+	Args    []string
+}
+
+// commandArgs lists the number of arguments each script command accepts, as
+// a minimum and maximum.
+var commandArgs = map[string][2]int{
+	"load":   {1, 2},
+	"play":   {0, 0},
+	"pause":  {0, 0},
+	"cue":    {0, 0},
+	"end":    {0, 0},
+	"eject":  {0, 0},
+	"onair":  {0, 0},
+	"offair": {0, 0},
+	"master": {0, 0},
+	"sync":   {0, 0},
+	"unsync": {0, 0},
+	"pitch":  {1, 1},
+}
+
+// trackSlots maps the slot names accepted by the load command.
+var trackSlots = map[string]prolink.TrackSlot{
+	"usb":       prolink.TrackSlotUSB,
+	"sd":        prolink.TrackSlotSD,
+	"rekordbox": prolink.TrackSlotRB,
+}
+
+// parseScript reads a script of player events. Each line is formatted as
+//
+//	<time> <player> <command> [args...]
+//
+// Where time is a duration since the start of the script, such as 1m30s.
+// Blank lines and lines starting with # are ignored.
+func parseScript(r io.Reader) ([]*scriptEvent, error) {
+	events := []*scriptEvent{}
+	scanner := bufio.NewScanner(r)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("Line %d: expected <time> <player> <command>", line)
+		}
+
+		at, err := time.ParseDuration(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: invalid time: %s", line, err)
+		}
+
+		player, err := strconv.ParseUint(fields[1], 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: invalid player: %s", line, err)
+		}
+
+		command, args := fields[2], fields[3:]
+
+		argRange, ok := commandArgs[command]
+		if !ok {
+			return nil, fmt.Errorf("Line %d: unknown command %q", line, command)
+		}
+
+		if len(args) < argRange[0] || len(args) > argRange[1] {
+			return nil, fmt.Errorf("Line %d: wrong number of arguments for %s", line, command)
+		}
+
+		events = append(events, &scriptEvent{
+			At:      at,
+			Player:  prolink.DeviceID(player),
+			Command: command,
+			Args:    args,
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].At < events[j].At
+	})
+
+	return events, nil
+}
+
+// defaultScript constructs a script in which each player takes a turn playing
+// a track, mixing from one player into the next.
+func defaultScript(players int) []*scriptEvent {
+	event := func(at time.Duration, player int, command string, args ...string) *scriptEvent {
+		return &scriptEvent{
+			At:      at,
+			Player:  prolink.DeviceID(player),
+			Command: command,
+			Args:    args,
+		}
+	}
+
+	events := []*scriptEvent{}
+
+	for i := 1; i <= players; i++ {
+		events = append(events, event(0, i, "load", strconv.Itoa(i)))
+	}
+
+	events = append(events,
+		event(0, 1, "master"),
+		event(0, 1, "onair"),
+		event(time.Second, 1, "play"),
+	)
+
+	for i := 2; i <= players; i++ {
+		start := time.Duration(i-1) * 30 * time.Second
+
+		events = append(events,
+			event(start, i, "sync"),
+			event(start, i, "play"),
+			event(start+5*time.Second, i, "onair"),
+			event(start+10*time.Second, i, "master"),
+			event(start+15*time.Second, i-1, "offair"),
+			event(start+16*time.Second, i-1, "cue"),
+		)
+	}
+
+	return events
+}
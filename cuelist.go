@@ -0,0 +1,292 @@
+package prolink
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"sort"
+	"time"
+	"unicode/utf16"
+
+	"go.evanpurkhiser.com/prolink/dbserver"
+)
+
+// cueListEntrySize is the number of bytes describing each cue of a cue list
+// served by players which do not support extended cue lists.
+const cueListEntrySize = 0x24
+
+// Sizes and offsets of each cue of an extended cue list. Every entry holds the
+// loop end, while the comment and color are only present in longer entries.
+// The color follows the comment after four unknown bytes, as read by
+// beat-link.
+const (
+	cueListExtMinSize       = 0x14
+	cueListExtCommentOffset = 0x4a
+	cueListExtColorOffset   = 0x4e
+)
+
+// Kinds of cues of an extended cue list.
+const (
+	cueKindCue  = 0x01
+	cueKindLoop = 0x02
+)
+
+// Cue is a memory point, hot cue or saved loop of a track.
+type Cue struct {
+	// HotCue is the hot cue button the cue is assigned to, from 1 for hot cue
+	// A to 8 for hot cue H. Zero for memory points.
+	HotCue uint8
+
+	Position time.Duration
+
+	// Loop is set for saved loops, which end at the LoopEnd.
+	Loop    bool
+	LoopEnd time.Duration
+
+	// Comment, ColorID and Color are only known for cue lists retrieved from
+	// players supporting extended cue lists. ColorID is the index of the color
+	// within the rekordbox color palette, and Color is the color the cue is
+	// shown in, which is transparent when the cue has no color.
+	Comment string
+	ColorID uint8
+	Color   color.RGBA
+}
+
+// HotCueName is the letter of the hot cue button of the cue, or an empty
+// string for memory points.
+func (c *Cue) HotCueName() string {
+	if c.HotCue == 0 {
+		return ""
+	}
+
+	return string(rune('A' + c.HotCue - 1))
+}
+
+// CueList lists the memory points, hot cues and saved loops of a track.
+type CueList struct {
+	// Cues are ordered by their position in the track.
+	Cues []Cue
+
+	// Extended is set when the cue list includes comments and colors.
+	Extended bool
+}
+
+// HotCues lists the hot cues and hot loops of the track.
+func (l *CueList) HotCues() []Cue {
+	cues := []Cue{}
+	for _, c := range l.Cues {
+		if c.HotCue != 0 {
+			cues = append(cues, c)
+		}
+	}
+
+	return cues
+}
+
+// MemoryPoints lists the memory points and saved loops of the track which are
+// not assigned to hot cues.
+func (l *CueList) MemoryPoints() []Cue {
+	cues := []Cue{}
+	for _, c := range l.Cues {
+		if c.HotCue == 0 {
+			cues = append(cues, c)
+		}
+	}
+
+	return cues
+}
+
+// Between lists the cues positioned after from, up to and including to. This
+// is useful to find the cues passed by a player between status updates.
+func (l *CueList) Between(from, to time.Duration) []Cue {
+	cues := []Cue{}
+	for _, c := range l.Cues {
+		if c.Position > from && c.Position <= to {
+			cues = append(cues, c)
+		}
+	}
+
+	return cues
+}
+
+// sortCues orders the cues by their position in the track.
+func (l *CueList) sortCues() {
+	sort.SliceStable(l.Cues, func(i, j int) bool {
+		return l.Cues[i].Position < l.Cues[j].Position
+	})
+}
+
+// halfFrames converts a position in half frames, 150ths of a second, into a
+// duration.
+func halfFrames(position uint32) time.Duration {
+	return time.Duration(position) * time.Second / 150
+}
+
+// MarshalBinary encodes the cue list in the extended form served by the
+// remote database.
+func (l *CueList) MarshalBinary() ([]byte, error) {
+	data := []byte{}
+
+	for _, c := range l.Cues {
+		comment := []byte{}
+		if c.Comment != "" {
+			for _, u := range utf16.Encode([]rune(c.Comment + "\x00")) {
+				comment = append(comment, byte(u), byte(u>>8))
+			}
+		}
+
+		entry := make([]byte, cueListExtColorOffset+len(comment)+4)
+
+		kind := byte(cueKindCue)
+		if c.Loop {
+			kind = cueKindLoop
+		}
+
+		binary.LittleEndian.PutUint32(entry[0x00:], uint32(len(entry)))
+		entry[0x04] = c.HotCue
+		entry[0x06] = kind
+		binary.LittleEndian.PutUint32(entry[0x0c:], uint32(c.Position/time.Millisecond))
+		binary.LittleEndian.PutUint32(entry[0x10:], uint32(c.LoopEnd/time.Millisecond))
+		binary.LittleEndian.PutUint16(entry[0x48:], uint16(len(comment)))
+		copy(entry[cueListExtCommentOffset:], comment)
+
+		colors := entry[cueListExtColorOffset+len(comment):]
+		colors[0] = c.ColorID
+		colors[1] = c.Color.R
+		colors[2] = c.Color.G
+		colors[3] = c.Color.B
+
+		data = append(data, entry...)
+	}
+
+	return data, nil
+}
+
+// parseCueList decodes the cue list served by players which do not support
+// extended cue lists.
+func parseCueList(data []byte) (*CueList, error) {
+	list := &CueList{Cues: []Cue{}}
+
+	for i := 0; i+cueListEntrySize <= len(data); i += cueListEntrySize {
+		entry := data[i : i+cueListEntrySize]
+
+		// Unused entries have neither the cue flag or a hot cue set
+		if entry[0x01] == 0 && entry[0x02] == 0 {
+			continue
+		}
+
+		cue := Cue{
+			HotCue:   entry[0x02],
+			Position: halfFrames(binary.LittleEndian.Uint32(entry[0x0c:])),
+			Loop:     entry[0x00] != 0,
+		}
+
+		if cue.Loop {
+			cue.LoopEnd = halfFrames(binary.LittleEndian.Uint32(entry[0x10:]))
+		}
+
+		list.Cues = append(list.Cues, cue)
+	}
+
+	list.sortCues()
+
+	return list, nil
+}
+
+// parseCueListExt decodes an extended cue list.
+func parseCueListExt(data []byte) (*CueList, error) {
+	list := &CueList{Cues: []Cue{}, Extended: true}
+
+	for len(data) > 0 {
+		if len(data) < cueListExtMinSize {
+			return nil, fmt.Errorf("Cue list entry is too short (%d bytes)", len(data))
+		}
+
+		size := binary.LittleEndian.Uint32(data)
+		if size < cueListExtMinSize || uint64(size) > uint64(len(data)) {
+			return nil, fmt.Errorf("Cue list entry has an invalid size (%d bytes)", size)
+		}
+
+		entry := data[:size]
+		data = data[size:]
+
+		// Unused entries have neither the cue kind or a hot cue set
+		if entry[0x06] == 0 && entry[0x04] == 0 {
+			continue
+		}
+
+		cue := Cue{
+			HotCue:   entry[0x04],
+			Position: time.Duration(binary.LittleEndian.Uint32(entry[0x0c:])) * time.Millisecond,
+			Loop:     entry[0x06] == cueKindLoop,
+		}
+
+		if cue.Loop {
+			cue.LoopEnd = time.Duration(binary.LittleEndian.Uint32(entry[0x10:])) * time.Millisecond
+		}
+
+		if len(entry) < cueListExtCommentOffset {
+			list.Cues = append(list.Cues, cue)
+			continue
+		}
+
+		commentSize := int(binary.LittleEndian.Uint16(entry[0x48:]))
+		if cueListExtCommentOffset+commentSize > len(entry) {
+			return nil, fmt.Errorf("Cue list comment is too long (%d bytes)", commentSize)
+		}
+
+		comment := entry[cueListExtCommentOffset : cueListExtCommentOffset+commentSize]
+
+		units := make([]uint16, 0, len(comment)/2)
+		for i := 0; i+1 < len(comment); i += 2 {
+			units = append(units, binary.LittleEndian.Uint16(comment[i:]))
+		}
+
+		// Strip the trailing NUL of the comment
+		if n := len(units); n > 0 && units[n-1] == 0 {
+			units = units[:n-1]
+		}
+
+		cue.Comment = string(utf16.Decode(units))
+
+		if offset := cueListExtColorOffset + commentSize; offset+4 <= len(entry) {
+			colors := entry[offset:]
+			cue.ColorID = colors[0]
+
+			if colors[1] != 0 || colors[2] != 0 || colors[3] != 0 {
+				cue.Color = color.RGBA{colors[1], colors[2], colors[3], 0xff}
+			}
+		}
+
+		list.Cues = append(list.Cues, cue)
+	}
+
+	list.sortCues()
+
+	return list, nil
+}
+
+// GetCueList queries the remote database for the memory points, hot cues and
+// saved loops of the track. Extended cue lists are requested first, falling
+// back to cue lists without comments and colors for players which do not
+// support them.
+func (rd *RemoteDB) GetCueList(q *TrackQuery) (*CueList, error) {
+	data, err := rd.dataRequest(q, dbserver.MsgCueListExt, dbserver.MsgCueListExtData,
+		dbserver.Uint32(q.TrackID),
+		dbserver.Uint32(0),
+	)
+	if err == nil {
+		return parseCueListExt(data)
+	}
+
+	if err != ErrUnavailable {
+		return nil, err
+	}
+
+	data, err = rd.dataRequest(q, dbserver.MsgCueList, dbserver.MsgCueListData, dbserver.Uint32(q.TrackID))
+	if err != nil {
+		return nil, err
+	}
+
+	return parseCueList(data)
+}
@@ -0,0 +1,137 @@
+package prolink
+
+import (
+	"image/color"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseCueList(t *testing.T) {
+	data := []byte{
+		// Hot cue B, a loop from 2 to 3 seconds
+		0x01, 0x01, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x2c, 0x01, 0x00, 0x00, 0xc2, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+
+		// Unused entry
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x96, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+
+		// Memory point at 1 second
+		0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x96, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+
+	list, err := parseCueList(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &CueList{Cues: []Cue{
+		{Position: time.Second},
+		{HotCue: 2, Position: 2 * time.Second, Loop: true, LoopEnd: 3 * time.Second},
+	}}
+
+	if !reflect.DeepEqual(list, want) {
+		t.Errorf("got %+v, want %+v", list, want)
+	}
+}
+
+func TestParseCueListExt(t *testing.T) {
+	data := []byte{
+		// Hot cue A at 1.5 seconds with the comment "Drop", colored with
+		// palette color 0x2a
+		0x5c, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0xdc, 0x05, 0x00, 0x00, 0xff, 0xff, 0xff, 0xff, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x0a, 0x00, 'D', 0x00, 'r', 0x00, 'o', 0x00, 'p', 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x2a, 0xff, 0x12, 0x34,
+
+		// Unused entry
+		0x38, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0xff, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+
+		// Memory point at 1 second, without a comment or color
+		0x38, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0xe8, 0x03, 0x00, 0x00, 0xff, 0xff, 0xff, 0xff, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+
+	list, err := parseCueListExt(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &CueList{Extended: true, Cues: []Cue{
+		{Position: time.Second},
+		{
+			HotCue:   1,
+			Position: 1500 * time.Millisecond,
+			Comment:  "Drop",
+			ColorID:  0x2a,
+			Color:    color.RGBA{0xff, 0x12, 0x34, 0xff},
+		},
+	}}
+
+	if !reflect.DeepEqual(list, want) {
+		t.Errorf("got %+v, want %+v", list, want)
+	}
+
+	if _, err := parseCueListExt(data[:len(data)-1]); err == nil {
+		t.Error("expected an error for a truncated entry")
+	}
+}
+
+func TestCueListMarshalBinary(t *testing.T) {
+	list := &CueList{Extended: true, Cues: []Cue{
+		{Position: 500 * time.Millisecond},
+		{HotCue: 3, Position: time.Second, Loop: true, LoopEnd: 2 * time.Second, Comment: "Loop"},
+		{HotCue: 1, Position: 4 * time.Second, ColorID: 1, Color: color.RGBA{0x30, 0x5a, 0xff, 0xff}},
+	}}
+
+	data, err := list.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := parseCueListExt(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, list) {
+		t.Errorf("got %+v, want %+v", got, list)
+	}
+}
+
+func TestCueListBetween(t *testing.T) {
+	list := &CueList{Cues: []Cue{
+		{Position: 1 * time.Second},
+		{HotCue: 1, Position: 2 * time.Second},
+		{Position: 3 * time.Second},
+	}}
+
+	if got := len(list.HotCues()); got != 1 {
+		t.Errorf("got %d hot cues, want 1", got)
+	}
+
+	if got := len(list.MemoryPoints()); got != 2 {
+		t.Errorf("got %d memory points, want 2", got)
+	}
+
+	between := list.Between(1500*time.Millisecond, 3*time.Second)
+	if len(between) != 2 || between[0].HotCue != 1 {
+		t.Errorf("got %+v between 1.5s and 3s", between)
+	}
+}
@@ -0,0 +1,210 @@
+// Package dbserver implements the message format used to communicate with the
+// remote database server of CDJs and rekordbox.
+//
+// Messages are composed of typed fields. Each message starts with a magic
+// number, transaction ID, message type, argument count and a blob listing the
+// type of each argument, followed by the arguments.
+package dbserver
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf16"
+)
+
+// ErrMalformed is wrapped by errors returned when data read from the server is
+// not a valid field or message.
+var ErrMalformed = errors.New("Malformed remote database message")
+
+// Field type tags which precede each field.
+const (
+	tagUint8  byte = 0x0f
+	tagUint16 byte = 0x10
+	tagUint32 byte = 0x11
+	tagBlob   byte = 0x14
+	tagString byte = 0x26
+)
+
+// Argument type tags, listed in the argument tag blob of each message.
+const (
+	argTagString byte = 0x02
+	argTagBlob   byte = 0x03
+	argTagNumber byte = 0x06
+)
+
+// Blobs and strings longer than this are considered malformed, rather than
+// attempting to read them.
+const maxFieldLength = 1 << 24
+
+// Field is a single typed value of a message.
+type Field interface {
+	// Bytes encodes the field, including its type tag.
+	Bytes() []byte
+
+	// argTag is the tag identifying the field in the argument tag blob.
+	argTag() byte
+}
+
+// Uint8 is a one byte number field.
+type Uint8 uint8
+
+// Bytes implements the Field interface.
+func (f Uint8) Bytes() []byte { return []byte{tagUint8, byte(f)} }
+
+func (f Uint8) argTag() byte { return argTagNumber }
+
+// Uint16 is a two byte number field.
+type Uint16 uint16
+
+// Bytes implements the Field interface.
+func (f Uint16) Bytes() []byte {
+	b := []byte{tagUint16, 0, 0}
+	binary.BigEndian.PutUint16(b[1:], uint16(f))
+
+	return b
+}
+
+func (f Uint16) argTag() byte { return argTagNumber }
+
+// Uint32 is a four byte number field.
+type Uint32 uint32
+
+// Bytes implements the Field interface.
+func (f Uint32) Bytes() []byte {
+	b := []byte{tagUint32, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(b[1:], uint32(f))
+
+	return b
+}
+
+func (f Uint32) argTag() byte { return argTagNumber }
+
+// Blob is a length prefixed binary field.
+type Blob []byte
+
+// Bytes implements the Field interface.
+func (f Blob) Bytes() []byte {
+	b := []byte{tagBlob, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(b[1:], uint32(len(f)))
+
+	return append(b, f...)
+}
+
+func (f Blob) argTag() byte { return argTagBlob }
+
+// String is a string field, encoded as NUL terminated UTF-16 prefixed with the
+// number of UTF-16 code units.
+type String string
+
+// Bytes implements the Field interface.
+func (f String) Bytes() []byte {
+	units := utf16.Encode([]rune(string(f) + "\x00"))
+
+	b := make([]byte, 5, 5+len(units)*2)
+	b[0] = tagString
+	binary.BigEndian.PutUint32(b[1:], uint32(len(units)))
+
+	for _, u := range units {
+		b = append(b, byte(u>>8), byte(u))
+	}
+
+	return b
+}
+
+func (f String) argTag() byte { return argTagString }
+
+// Size is the number of bytes of the UTF-16 encoded string, including the NUL
+// terminator. Some messages give the size of a string preceding it.
+func (f String) Size() Uint32 {
+	return Uint32((len(utf16.Encode([]rune(string(f)))) + 1) * 2)
+}
+
+// number returns the value of a number field.
+func number(f Field) (uint32, bool) {
+	switch v := f.(type) {
+	case Uint8:
+		return uint32(v), true
+	case Uint16:
+		return uint32(v), true
+	case Uint32:
+		return uint32(v), true
+	}
+
+	return 0, false
+}
+
+// ReadField reads a single field from the stream. io.EOF is returned only if
+// no data was read.
+func ReadField(r io.Reader) (Field, error) {
+	tag := make([]byte, 1)
+	if _, err := io.ReadFull(r, tag); err != nil {
+		return nil, err
+	}
+
+	read := func(n int) ([]byte, error) {
+		data := make([]byte, n)
+
+		_, err := io.ReadFull(r, data)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return data, err
+	}
+
+	var size int
+
+	switch tag[0] {
+	case tagUint8:
+		size = 1
+	case tagUint16:
+		size = 2
+	case tagUint32, tagBlob, tagString:
+		size = 4
+	default:
+		return nil, fmt.Errorf("%w: unknown field type 0x%02x", ErrMalformed, tag[0])
+	}
+
+	data, err := read(size)
+	if err != nil {
+		return nil, err
+	}
+
+	switch tag[0] {
+	case tagUint8:
+		return Uint8(data[0]), nil
+	case tagUint16:
+		return Uint16(binary.BigEndian.Uint16(data)), nil
+	case tagUint32:
+		return Uint32(binary.BigEndian.Uint32(data)), nil
+	}
+
+	length := binary.BigEndian.Uint32(data)
+	if length > maxFieldLength {
+		return nil, fmt.Errorf("%w: field length %d is too large", ErrMalformed, length)
+	}
+
+	if tag[0] == tagBlob {
+		blob, err := read(int(length))
+		return Blob(blob), err
+	}
+
+	data, err = read(int(length) * 2)
+	if err != nil {
+		return nil, err
+	}
+
+	units := make([]uint16, 0, length)
+	for i := 0; i < len(data); i += 2 {
+		units = append(units, binary.BigEndian.Uint16(data[i:]))
+	}
+
+	// Strip the NUL terminator
+	if len(units) > 0 && units[len(units)-1] == 0 {
+		units = units[:len(units)-1]
+	}
+
+	return String(utf16.Decode(units)), nil
+}
@@ -0,0 +1,142 @@
+package dbserver
+
+import (
+	"fmt"
+)
+
+// ItemType identifies the kind of a rendered menu item.
+type ItemType uint32
+
+// Known menu item types. The type of items listing tracks may include the
+// type of the secondary label in the second byte, see Base.
+const (
+	ItemFolder         ItemType = 0x01
+	ItemAlbum          ItemType = 0x02
+	ItemDisc           ItemType = 0x03
+	ItemTitle          ItemType = 0x04
+	ItemGenre          ItemType = 0x06
+	ItemArtist         ItemType = 0x07
+	ItemPlaylist       ItemType = 0x08
+	ItemRating         ItemType = 0x0a
+	ItemDuration       ItemType = 0x0b
+	ItemTempo          ItemType = 0x0d
+	ItemLabel          ItemType = 0x0e
+	ItemKey            ItemType = 0x0f
+	ItemBitRate        ItemType = 0x10
+	ItemYear           ItemType = 0x11
+	ItemColor          ItemType = 0x13
+	ItemComment        ItemType = 0x23
+	ItemHistory        ItemType = 0x24
+	ItemOriginalArtist ItemType = 0x28
+	ItemRemixer        ItemType = 0x29
+	ItemDateAdded      ItemType = 0x2e
+	ItemPath           ItemType = 0x2f
+
+	ItemGenreMenu    ItemType = 0x80
+	ItemArtistMenu   ItemType = 0x81
+	ItemAlbumMenu    ItemType = 0x82
+	ItemTrackMenu    ItemType = 0x83
+	ItemPlaylistMenu ItemType = 0x84
+	ItemBPMMenu      ItemType = 0x85
+	ItemKeyMenu      ItemType = 0x8b
+	ItemFolderMenu   ItemType = 0x90
+	ItemSearchMenu   ItemType = 0x91
+	ItemHistoryMenu  ItemType = 0x95
+)
+
+// Color items are labeled with the name of the color of a track. ItemColor is
+// used for tracks without a color.
+const (
+	ItemColorPink   ItemType = 0x14
+	ItemColorRed    ItemType = 0x15
+	ItemColorOrange ItemType = 0x16
+	ItemColorYellow ItemType = 0x17
+	ItemColorGreen  ItemType = 0x18
+	ItemColorAqua   ItemType = 0x19
+	ItemColorBlue   ItemType = 0x1a
+	ItemColorPurple ItemType = 0x1b
+)
+
+// Base returns the type of the item, without the type of its secondary label.
+func (t ItemType) Base() ItemType {
+	return t & 0xff
+}
+
+// MenuItem is a single item of a rendered menu, sent as a MsgMenuItem
+// message.
+type MenuItem struct {
+	ParentID  uint32
+	ID        uint32
+	Label     string
+	Label2    string
+	Type      ItemType
+	Flags     uint32
+	ArtworkID uint32
+}
+
+// ParseMenuItem decodes a MsgMenuItem message.
+func ParseMenuItem(m *Message) (*MenuItem, error) {
+	if m.Type != MsgMenuItem {
+		return nil, fmt.Errorf("%w: message 0x%04x is not a menu item", ErrMalformed, m.Type)
+	}
+
+	item := &MenuItem{}
+
+	numbers := []struct {
+		index int
+		value *uint32
+	}{
+		{0, &item.ParentID},
+		{1, &item.ID},
+		{7, &item.Flags},
+		{8, &item.ArtworkID},
+	}
+
+	for _, n := range numbers {
+		v, err := m.NumberArg(n.index)
+		if err != nil {
+			return nil, err
+		}
+
+		*n.value = v
+	}
+
+	itemType, err := m.NumberArg(6)
+	if err != nil {
+		return nil, err
+	}
+
+	item.Type = ItemType(itemType)
+
+	if item.Label, err = m.StringArg(3); err != nil {
+		return nil, err
+	}
+
+	if item.Label2, err = m.StringArg(5); err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
+
+// Message encodes the menu item as a message with the transaction ID.
+func (i *MenuItem) Message(txID uint32) *Message {
+	return &Message{
+		TxID: txID,
+		Type: MsgMenuItem,
+		Args: []Field{
+			Uint32(i.ParentID),
+			Uint32(i.ID),
+			String(i.Label).Size(),
+			String(i.Label),
+			String(i.Label2).Size(),
+			String(i.Label2),
+			Uint32(i.Type),
+			Uint32(i.Flags),
+			Uint32(i.ArtworkID),
+			Uint32(0),
+			Uint32(0),
+			Uint32(0),
+		},
+	}
+}
@@ -0,0 +1,268 @@
+package dbserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Magic is the number each message starts with.
+const Magic = 0x872349ae
+
+// SetupTxID is the transaction ID of the setup message sent when opening a
+// connection.
+const SetupTxID = 0xfffffffe
+
+// MaxArgs is the number of arguments a message may have.
+const MaxArgs = 12
+
+// Greeting is the field exchanged when first connecting to the server, which
+// is echoed back by the server.
+var Greeting = Uint32(1)
+
+// MessageType identifies the kind of request or response of a message.
+type MessageType uint16
+
+// Known message types.
+const (
+	MsgSetup    MessageType = 0x0000
+	MsgTeardown MessageType = 0x0100
+
+	MsgRootMenu          MessageType = 0x1000
+	MsgGenreMenu         MessageType = 0x1001
+	MsgArtistMenu        MessageType = 0x1002
+	MsgAlbumMenu         MessageType = 0x1003
+	MsgTrackMenu         MessageType = 0x1004
+	MsgBPMMenu           MessageType = 0x1006
+	MsgHistoryMenu       MessageType = 0x1012
+	MsgKeyMenu           MessageType = 0x1014
+	MsgGenreArtistMenu   MessageType = 0x1101
+	MsgArtistAlbumMenu   MessageType = 0x1102
+	MsgAlbumTrackMenu    MessageType = 0x1104
+	MsgPlaylist          MessageType = 0x1105
+	MsgBPMRangeTrackMenu MessageType = 0x1106
+	MsgHistoryTrackMenu  MessageType = 0x1112
+	MsgSearchMenu        MessageType = 0x1300
+
+	MsgMetadata        MessageType = 0x2002
+	MsgArtwork         MessageType = 0x2003
+	MsgWaveformPreview MessageType = 0x2004
+	MsgTrackInfo       MessageType = 0x2102
+	MsgCueList         MessageType = 0x2104
+	MsgUnanalyzedMeta  MessageType = 0x2202
+	MsgBeatGrid        MessageType = 0x2204
+	MsgWaveformDetail  MessageType = 0x2904
+	MsgCueListExt      MessageType = 0x2b04
+	MsgAnlzTag         MessageType = 0x2c04
+	MsgRenderMenu      MessageType = 0x3000
+
+	MsgSuccess             MessageType = 0x4000
+	MsgMenuHeader          MessageType = 0x4001
+	MsgArtworkReply        MessageType = 0x4002
+	MsgUnavailable         MessageType = 0x4003
+	MsgMenuItem            MessageType = 0x4101
+	MsgMenuFooter          MessageType = 0x4201
+	MsgWaveformPreviewData MessageType = 0x4402
+	MsgBeatGridData        MessageType = 0x4602
+	MsgCueListData         MessageType = 0x4702
+	MsgWaveformDetailData  MessageType = 0x4a02
+	MsgCueListExtData      MessageType = 0x4e02
+	MsgAnlzTagData         MessageType = 0x4f02
+)
+
+// Menu identifies the menu of the player a request is made for.
+type Menu byte
+
+// Known menus.
+const (
+	MenuMain Menu = 0x01
+	MenuData Menu = 0x08
+)
+
+// TrackType identifies the kind of track a request is made for.
+type TrackType byte
+
+// Known track types.
+const (
+	TrackTypeRekordbox  TrackType = 0x01
+	TrackTypeUnanalyzed TrackType = 0x02
+	TrackTypeCD         TrackType = 0x05
+)
+
+// DMST constructs the first argument of most requests, identifying the device
+// making the request, the menu and slot the request is for, and the type of
+// track being requested.
+func DMST(deviceID byte, menu Menu, slot byte, trackType TrackType) Uint32 {
+	return Uint32(uint32(deviceID)<<24 | uint32(menu)<<16 | uint32(slot)<<8 | uint32(trackType))
+}
+
+// AnlzCode encodes a code of up to four characters, such as the "PWV4" tag or
+// the "EXT" extension of a rekordbox analysis file, as an argument of a
+// MsgAnlzTag request.
+func AnlzCode(code string) Uint32 {
+	b := make([]byte, 4)
+	copy(b, code)
+
+	return Uint32(binary.LittleEndian.Uint32(b))
+}
+
+// Message is a single request or response.
+type Message struct {
+	TxID uint32
+	Type MessageType
+	Args []Field
+}
+
+// omitted reports if the blob argument is left out of the encoded message, as
+// players do when the number argument preceding it gives a length of zero.
+func omitted(prev, arg Field) bool {
+	blob, ok := arg.(Blob)
+	if !ok || len(blob) > 0 {
+		return false
+	}
+
+	n, ok := number(prev)
+	return ok && n == 0
+}
+
+// MarshalBinary encodes the message. Empty blob arguments preceded by a zero
+// number argument are omitted, as they are by players.
+func (m *Message) MarshalBinary() ([]byte, error) {
+	if len(m.Args) > MaxArgs {
+		return nil, fmt.Errorf("Message has %d arguments, at most %d are allowed", len(m.Args), MaxArgs)
+	}
+
+	tags := make(Blob, MaxArgs)
+	for i, arg := range m.Args {
+		tags[i] = arg.argTag()
+	}
+
+	fields := []Field{
+		Uint32(Magic),
+		Uint32(m.TxID),
+		Uint16(m.Type),
+		Uint8(len(m.Args)),
+		tags,
+	}
+
+	buf := &bytes.Buffer{}
+
+	for _, f := range fields {
+		buf.Write(f.Bytes())
+	}
+
+	for i, arg := range m.Args {
+		if i > 0 && omitted(m.Args[i-1], arg) {
+			continue
+		}
+
+		buf.Write(arg.Bytes())
+	}
+
+	return buf.Bytes(), nil
+}
+
+// NumberArg returns the value of the numeric argument at index i.
+func (m *Message) NumberArg(i int) (uint32, error) {
+	if i >= len(m.Args) {
+		return 0, fmt.Errorf("%w: missing argument %d of message 0x%04x", ErrMalformed, i, m.Type)
+	}
+
+	v, ok := number(m.Args[i])
+	if !ok {
+		return 0, fmt.Errorf("%w: argument %d of message 0x%04x is not a number", ErrMalformed, i, m.Type)
+	}
+
+	return v, nil
+}
+
+// StringArg returns the value of the string argument at index i.
+func (m *Message) StringArg(i int) (string, error) {
+	if i >= len(m.Args) {
+		return "", fmt.Errorf("%w: missing argument %d of message 0x%04x", ErrMalformed, i, m.Type)
+	}
+
+	v, ok := m.Args[i].(String)
+	if !ok {
+		return "", fmt.Errorf("%w: argument %d of message 0x%04x is not a string", ErrMalformed, i, m.Type)
+	}
+
+	return string(v), nil
+}
+
+// BlobArg returns the value of the blob argument at index i.
+func (m *Message) BlobArg(i int) ([]byte, error) {
+	if i >= len(m.Args) {
+		return nil, fmt.Errorf("%w: missing argument %d of message 0x%04x", ErrMalformed, i, m.Type)
+	}
+
+	v, ok := m.Args[i].(Blob)
+	if !ok {
+		return nil, fmt.Errorf("%w: argument %d of message 0x%04x is not a blob", ErrMalformed, i, m.Type)
+	}
+
+	return []byte(v), nil
+}
+
+// ReadMessage reads a single message from the stream. io.EOF is returned only
+// if no data was read. Blob arguments omitted from the stream because their
+// length was given as zero are read as an empty Blob.
+func ReadMessage(r io.Reader) (*Message, error) {
+	header := make([]Field, 5)
+
+	for i := range header {
+		field, err := ReadField(r)
+		if err == io.EOF && i > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		header[i] = field
+	}
+
+	magic, ok := header[0].(Uint32)
+	if !ok || magic != Magic {
+		return nil, fmt.Errorf("%w: message does not start with the magic number", ErrMalformed)
+	}
+
+	txID, ok1 := header[1].(Uint32)
+	kind, ok2 := header[2].(Uint16)
+	argc, ok3 := header[3].(Uint8)
+	tags, ok4 := header[4].(Blob)
+
+	if !ok1 || !ok2 || !ok3 || !ok4 {
+		return nil, fmt.Errorf("%w: unexpected message header field types", ErrMalformed)
+	}
+
+	msg := &Message{
+		TxID: uint32(txID),
+		Type: MessageType(kind),
+		Args: make([]Field, 0, argc),
+	}
+
+	for i := 0; i < int(argc); i++ {
+		// Players omit a blob argument entirely when the number argument
+		// preceding it, giving its length, is zero.
+		if i > 0 && i < len(tags) && tags[i] == argTagBlob && omitted(msg.Args[i-1], Blob{}) {
+			msg.Args = append(msg.Args, Blob{})
+			continue
+		}
+
+		arg, err := ReadField(r)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		msg.Args = append(msg.Args, arg)
+	}
+
+	return msg, nil
+}
@@ -0,0 +1,180 @@
+package dbserver
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  *Message
+	}{
+		{
+			name: "no arguments",
+			msg:  &Message{TxID: SetupTxID, Type: MsgSetup, Args: []Field{}},
+		},
+		{
+			name: "numbers",
+			msg: &Message{TxID: 1, Type: MsgMetadata, Args: []Field{
+				DMST(0x05, MenuMain, 0x03, TrackTypeRekordbox),
+				Uint32(42),
+			}},
+		},
+		{
+			name: "strings and blobs",
+			msg: &Message{TxID: 2, Type: MsgMenuItem, Args: []Field{
+				Uint32(1),
+				Uint32(2),
+				String("Track").Size(),
+				String("Track"),
+				Uint32(3),
+				Blob{0x01, 0x02, 0x03},
+			}},
+		},
+		{
+			name: "empty blob after zero length",
+			msg: &Message{TxID: 3, Type: MsgWaveformPreviewData, Args: []Field{
+				Uint32(0x2004),
+				Uint32(0),
+				Uint32(0),
+				Blob{},
+			}},
+		},
+		{
+			name: "empty blob after non-zero number",
+			msg: &Message{TxID: 4, Type: MsgArtworkReply, Args: []Field{
+				Uint32(1),
+				Blob{},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := tt.msg.MarshalBinary()
+			if err != nil {
+				t.Fatalf("MarshalBinary: %s", err)
+			}
+
+			got, err := ReadMessage(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("ReadMessage: %s", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.msg) {
+				t.Errorf("got %#v, want %#v", got, tt.msg)
+			}
+		})
+	}
+}
+
+func TestReadMessageOmittedBlob(t *testing.T) {
+	// A beat grid reply for a track that has no beat grid, as sent by a
+	// player. The blob argument is listed in the argument tags but left out
+	// of the stream, since its length is given as zero.
+	reply := []byte{
+		0x11, 0x87, 0x23, 0x49, 0xae, // magic
+		0x11, 0x00, 0x00, 0x00, 0x07, // transaction ID
+		0x10, 0x46, 0x02, // message type
+		0x0f, 0x04, // argument count
+		0x14, 0x00, 0x00, 0x00, 0x0c, // argument tags
+		0x06, 0x06, 0x06, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x11, 0x00, 0x00, 0x22, 0x04, // request type
+		0x11, 0x00, 0x00, 0x00, 0x00, // unknown
+		0x11, 0x00, 0x00, 0x00, 0x00, // blob length
+	}
+
+	footer := &Message{TxID: 8, Type: MsgMenuFooter, Args: []Field{}}
+	footerData, err := footer.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := bytes.NewReader(append(reply, footerData...))
+
+	msg, err := ReadMessage(r)
+	if err != nil {
+		t.Fatalf("ReadMessage: %s", err)
+	}
+
+	want := &Message{TxID: 7, Type: MsgBeatGridData, Args: []Field{
+		Uint32(0x2204),
+		Uint32(0),
+		Uint32(0),
+		Blob{},
+	}}
+
+	if !reflect.DeepEqual(msg, want) {
+		t.Errorf("got %#v, want %#v", msg, want)
+	}
+
+	data, err := want.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, reply) {
+		t.Errorf("MarshalBinary = % x, want % x", data, reply)
+	}
+
+	// The following message must be read intact
+	next, err := ReadMessage(r)
+	if err != nil {
+		t.Fatalf("ReadMessage of following message: %s", err)
+	}
+
+	if !reflect.DeepEqual(next, footer) {
+		t.Errorf("got %#v, want %#v", next, footer)
+	}
+
+	if _, err := ReadMessage(r); err != io.EOF {
+		t.Errorf("got %v at end of stream, want io.EOF", err)
+	}
+}
+
+func TestReadMessageMalformed(t *testing.T) {
+	valid, err := (&Message{TxID: 1, Type: MsgSuccess, Args: []Field{Uint32(1)}}).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{
+			name: "bad magic",
+			data: append([]byte{0x11, 0x00, 0x00, 0x00, 0x00}, valid[5:]...),
+			want: ErrMalformed,
+		},
+		{
+			name: "unknown field type",
+			data: append(append([]byte{}, valid[:len(valid)-5]...), 0x99),
+			want: ErrMalformed,
+		},
+		{
+			name: "truncated argument",
+			data: valid[:len(valid)-2],
+			want: io.ErrUnexpectedEOF,
+		},
+		{
+			name: "truncated header",
+			data: valid[:7],
+			want: io.ErrUnexpectedEOF,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadMessage(bytes.NewReader(tt.data))
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -1,8 +1,11 @@
 package prolink
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 )
 
@@ -38,6 +41,16 @@ func (d *Device) String() string {
 	return fmt.Sprintf("%s %02d @ %s [%s]", d.Name, d.ID, d.IP, d.MacAddr)
 }
 
+// MarshalBinary encodes the device as the keep alive packet devices use to
+// announce themselves on the network.
+func (d *Device) MarshalBinary() ([]byte, error) {
+	if len(d.MacAddr) < 6 || d.IP.To4() == nil {
+		return nil, fmt.Errorf("Device must have a hardware address and IPv4 address")
+	}
+
+	return getAnnouncePacket(d), nil
+}
+
 // A DeviceListener responds to devices being added and removed from the PRO DJ
 // LINK network.
 type DeviceListener interface {
@@ -51,33 +64,252 @@ type DeviceListenerFunc func(*Device)
 // OnChange implements the DeviceListener interface.
 func (f DeviceListenerFunc) OnChange(d *Device) { f(d) }
 
+// DeviceEventType represents the kind of change to a device on the network.
+type DeviceEventType string
+
+// Defined device event types.
+const (
+	DeviceAdded   DeviceEventType = "added"
+	DeviceRemoved DeviceEventType = "removed"
+)
+
+// DeviceEvent describes a device being added or removed from the network.
+type DeviceEvent struct {
+	Type   DeviceEventType
+	Device *Device
+}
+
+// DeviceConflict describes two devices on the PRO DJ LINK network that have
+// announced themselves using the same device ID.
+type DeviceConflict struct {
+	ID DeviceID
+
+	// Device is the device that announced itself using a conflicting ID.
+	Device *Device
+
+	// Existing is the device already known to be using the device ID. When the
+	// conflict is with the virtual CDJ this will be the virtual CDJ.
+	Existing *Device
+
+	// IsVirtualCDJ is true when the device ID conflicts with the virtual CDJ.
+	IsVirtualCDJ bool
+}
+
+// String returns a string representation of a device conflict.
+func (c *DeviceConflict) String() string {
+	return fmt.Sprintf("Device ID %02d conflict: %s and %s", c.ID, c.Existing, c.Device)
+}
+
+// A DeviceConflictListener responds to devices announcing themselves with a
+// device ID already in use on the PRO DJ LINK network.
+type DeviceConflictListener interface {
+	OnConflict(*DeviceConflict)
+}
+
+// The DeviceConflictListenerFunc is an adapater to allow a function to be used
+// as a listener for device conflicts.
+type DeviceConflictListenerFunc func(*DeviceConflict)
+
+// OnConflict implements the DeviceConflictListener interface.
+func (f DeviceConflictListenerFunc) OnConflict(c *DeviceConflict) { f(c) }
+
 // DeviceManager provides functionality for watching the connection status of
 // PRO DJ LINK devices on the network.
+//
+// Each listener is called with changes in the order they occurred, one at a
+// time. A slow listener does not delay other listeners.
 type DeviceManager struct {
-	delHandlers []DeviceListener
-	addHandlers []DeviceListener
-	devices     map[DeviceID]*Device
+	delHandlers      *handlerSet
+	addHandlers      *handlerSet
+	conflictHandlers *handlerSet
+	eventHandlers    *handlerSet
+	devices          map[DeviceID]*Device
+	done             chan bool
+
+	// conflicts tracks conflicts that have already been reported for each
+	// device ID, keyed by the MAC addresses of both conflicting devices.
+	conflicts map[DeviceID]map[string]bool
+
+	lock       sync.Mutex
+	virtualCDJ *Device
+	claimWatch func([]byte)
 }
 
 // OnDeviceAdded registers a listener that will be called when any PRO DJ LINK
 // devices are added to the network.
-func (m *DeviceManager) OnDeviceAdded(fn DeviceListener) {
-	m.addHandlers = append(m.addHandlers, fn)
+func (m *DeviceManager) OnDeviceAdded(fn DeviceListener) *Subscription {
+	return m.addHandlers.add(fn)
 }
 
 // OnDeviceRemoved registers a listener that will be called when any PRO DJ
 // LINK devices are removed from the network.
-func (m *DeviceManager) OnDeviceRemoved(fn DeviceListener) {
-	m.delHandlers = append(m.delHandlers, fn)
+func (m *DeviceManager) OnDeviceRemoved(fn DeviceListener) *Subscription {
+	return m.delHandlers.add(fn)
+}
+
+// DeviceEvents returns a channel which receives an event for every device
+// added or removed from the PRO DJ LINK network. The channel is closed once the
+// context is done.
+func (m *DeviceManager) DeviceEvents(ctx context.Context, config ChannelConfig) <-chan DeviceEvent {
+	ch := make(chan DeviceEvent, config.bufferSize())
+	sender := newChannelSender(config, ctx.Done())
+
+	// Added and removed events are delivered by a single handler, such that
+	// events are always received in the order they occurred.
+	handler := func(event DeviceEvent) {
+		trySend := func() bool {
+			select {
+			case ch <- event:
+				return true
+			default:
+				return false
+			}
+		}
+
+		send := func(done <-chan struct{}) {
+			select {
+			case ch <- event:
+			case <-done:
+			}
+		}
+
+		dropOldest := func() bool {
+			select {
+			case <-ch:
+				return true
+			default:
+				return false
+			}
+		}
+
+		sender.deliver(trySend, send, dropOldest)
+	}
+
+	sub := m.eventHandlers.add(handler)
+
+	go func() {
+		<-ctx.Done()
+		sub.Unsubscribe()
+		sender.close(func() { close(ch) })
+	}()
+
+	return ch
+}
+
+// notifyChange notifies listeners of the device being added or removed.
+func (m *DeviceManager) notifyChange(eventType DeviceEventType, dev *Device) {
+	listeners := m.addHandlers
+	if eventType == DeviceRemoved {
+		listeners = m.delHandlers
+	}
+
+	listeners.notify(func(h interface{}) { h.(DeviceListener).OnChange(dev) })
+
+	event := DeviceEvent{Type: eventType, Device: dev}
+	m.eventHandlers.notify(func(h interface{}) { h.(func(DeviceEvent))(event) })
+}
+
+// OnDeviceConflict registers a listener that will be called when a device
+// announces itself using a device ID that is already in use, either by another
+// device or by the virtual CDJ.
+func (m *DeviceManager) OnDeviceConflict(fn DeviceConflictListener) *Subscription {
+	return m.conflictHandlers.add(fn)
+}
+
+// setVirtualCDJ updates the virtual CDJ that announced devices are checked
+// against for conflicting device IDs.
+func (m *DeviceManager) setVirtualCDJ(vCDJ *Device) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.virtualCDJ = vCDJ
+}
+
+// setClaimWatch configures a function that will be given every packet received
+// on the announce port. Used while the virtual CDJ is claiming a device ID.
+func (m *DeviceManager) setClaimWatch(fn func([]byte)) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.claimWatch = fn
+}
+
+// defendDeviceID answers device ID claim packets claiming the device ID of the
+// virtual CDJ, as real players do, so that devices joining the network choose
+// another device ID. The lock must be held.
+func (m *DeviceManager) defendDeviceID(conn net.PacketConn, p []byte, from net.Addr, reportError errorReporter) {
+	vCDJ := m.virtualCDJ
+
+	// Claims are not defended while the virtual CDJ is claiming a device ID
+	if vCDJ == nil || m.claimWatch != nil {
+		return
+	}
+
+	id, ip, mac, ok := claimedDeviceID(p)
+	if !ok || id != vCDJ.ID || bytes.Equal(mac, vCDJ.MacAddr) {
+		return
+	}
+
+	if udpAddr, ok := from.(*net.UDPAddr); ok && ip == nil {
+		ip = udpAddr.IP
+	}
+
+	// Our own claim packets, received as they are broadcast
+	if ip.Equal(vCDJ.IP) {
+		return
+	}
+
+	addr := getBroadcastAddress(vCDJ)
+	if ip != nil {
+		addr = &net.UDPAddr{IP: ip, Port: announceAddr.Port}
+	}
+
+	packet := getIDInUsePacket(vCDJ)
+
+	if _, err := conn.WriteTo(packet, addr); err != nil {
+		reportError(addr, packet, err)
+	}
+}
+
+// reportConflict notifies conflict listeners of a device ID conflict. Each
+// conflicting device will only be reported once. The lock must be held.
+func (m *DeviceManager) reportConflict(conflict *DeviceConflict) {
+	key := conflict.Existing.MacAddr.String() + conflict.Device.MacAddr.String()
+
+	if m.conflicts[conflict.ID] == nil {
+		m.conflicts[conflict.ID] = map[string]bool{}
+	}
+
+	if m.conflicts[conflict.ID][key] {
+		return
+	}
+
+	m.conflicts[conflict.ID][key] = true
+
+	m.conflictHandlers.notify(func(h interface{}) {
+		h.(DeviceConflictListener).OnConflict(conflict)
+	})
 }
 
 // ActiveDeviceMap returns a mapping of device IDs to their associated devices.
 func (m *DeviceManager) ActiveDeviceMap() map[DeviceID]*Device {
-	return m.devices
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	devices := make(map[DeviceID]*Device, len(m.devices))
+
+	for id, dev := range m.devices {
+		devices[id] = dev
+	}
+
+	return devices
 }
 
 // ActiveDevices returns a list of active devices on the PRO DJ LINK network.
 func (m *DeviceManager) ActiveDevices() []*Device {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	devices := make([]*Device, 0, len(m.devices))
 
 	for _, dev := range m.devices {
@@ -88,71 +320,150 @@ func (m *DeviceManager) ActiveDevices() []*Device {
 }
 
 // activate triggers the DeviceManager to begin watching for device changes on
-// the PRO DJ LINK network.
-func (m *DeviceManager) activate(announceConn *net.UDPConn) {
+// the PRO DJ LINK network. Errors reading or parsing packets are given to the
+// errorReporter. The virtual CDJ may be nil when replaying a capture.
+func (m *DeviceManager) activate(announceConn net.PacketConn, vCDJ *Device, reportError errorReporter) {
+	m.virtualCDJ = vCDJ
+
 	timeouts := map[DeviceID]*time.Timer{}
 
-	timeoutTimer := func(dev *Device) {
-		timeouts[dev.ID] = time.NewTimer(deviceTimeout)
-		<-timeouts[dev.ID].C
+	timeoutTimer := func(dev *Device, timer *time.Timer) {
+		select {
+		case <-m.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		m.lock.Lock()
+		defer m.lock.Unlock()
 
 		// Device timeout expired. No longer active
 		delete(timeouts, dev.ID)
 		delete(m.devices, dev.ID)
+		delete(m.conflicts, dev.ID)
 
-		for _, h := range m.delHandlers {
-			go h.OnChange(dev)
-		}
+		m.notifyChange(DeviceRemoved, dev)
 	}
 
 	announceHandler := func() {
 		packet := make([]byte, announcePacketLen)
 
-		announceConn.Read(packet)
-		dev, err := deviceFromAnnouncePacket(packet)
+		n, addr, err := announceConn.ReadFrom(packet)
 		if err != nil {
+			select {
+			case <-m.done:
+			default:
+				reportError(nil, nil, err)
+			}
+
 			return
 		}
 
+		m.lock.Lock()
+		defer m.lock.Unlock()
+
+		if m.claimWatch != nil {
+			m.claimWatch(packet[:n])
+		}
+
+		// Device ID claim packets are also sent on the announce port
+		if n > 0x0A && packet[0x0A] != announceType {
+			m.defendDeviceID(announceConn, packet[:n], addr, reportError)
+			return
+		}
+
+		dev, err := deviceFromAnnouncePacket(packet[:n])
+		if err != nil {
+			reportError(addr, packet[:n], err)
+			return
+		}
+
+		// There is no virtual CDJ when replaying a capture
+		if vCDJ := m.virtualCDJ; vCDJ != nil {
+			// Our own virtual CDJ announcements
+			if bytes.Equal(dev.MacAddr, vCDJ.MacAddr) && dev.IP.Equal(vCDJ.IP) {
+				return
+			}
+
+			if dev.ID == vCDJ.ID {
+				m.reportConflict(&DeviceConflict{
+					ID:           dev.ID,
+					Device:       dev,
+					Existing:     vCDJ,
+					IsVirtualCDJ: true,
+				})
+			}
+		}
+
 		if dev.Name == VirtualCDJName {
 			return
 		}
 
 		// Update device keepalive
-		if dev, ok := m.devices[dev.ID]; ok {
+		if existing, ok := m.devices[dev.ID]; ok {
 			timeout, ok := timeouts[dev.ID]
 			if !ok {
 				return
 			}
 
+			isSameDevice := bytes.Equal(existing.MacAddr, dev.MacAddr) &&
+				existing.IP.Equal(dev.IP)
+
+			// Keep alives of a conflicting device must not keep the existing
+			// device from timing out
+			if !isSameDevice {
+				m.reportConflict(&DeviceConflict{
+					ID:       dev.ID,
+					Device:   dev,
+					Existing: existing,
+				})
+				return
+			}
+
 			timeout.Stop()
 			timeout.Reset(deviceTimeout)
-			dev.LastActive = time.Now()
+			existing.LastActive = time.Now()
 			return
 		}
 
 		// New device
 		m.devices[dev.ID] = dev
 
-		for _, h := range m.addHandlers {
-			go h.OnChange(dev)
-		}
+		m.notifyChange(DeviceAdded, dev)
 
-		go timeoutTimer(dev)
+		timeouts[dev.ID] = time.NewTimer(deviceTimeout)
+		go timeoutTimer(dev, timeouts[dev.ID])
 	}
 
 	// Begin listening for announce packets
 	go func() {
 		for {
-			announceHandler()
+			select {
+			case <-m.done:
+				return
+			default:
+				announceHandler()
+			}
 		}
 	}()
 }
 
+// deactivate stops the DeviceManager from watching for device changes. The
+// announce connection must be closed after deactivating to unblock any
+// pending reads.
+func (m *DeviceManager) deactivate() {
+	close(m.done)
+}
+
 func newDeviceManager() *DeviceManager {
 	return &DeviceManager{
-		addHandlers: []DeviceListener{},
-		delHandlers: []DeviceListener{},
-		devices:     map[DeviceID]*Device{},
+		addHandlers:      &handlerSet{},
+		delHandlers:      &handlerSet{},
+		conflictHandlers: &handlerSet{},
+		eventHandlers:    &handlerSet{},
+		devices:          map[DeviceID]*Device{},
+		conflicts:        map[DeviceID]map[string]bool{},
+		done:             make(chan bool),
 	}
 }
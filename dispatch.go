@@ -0,0 +1,247 @@
+package prolink
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// defaultQueueSize is the number of status updates buffered per handler and
+// player when no queue size is configured.
+const defaultQueueSize = 16
+
+// DispatchConfig configures how status updates are delivered to handlers.
+type DispatchConfig struct {
+	// Ordered enables delivering status updates to each handler in the order
+	// they were received. A queue is kept for each handler and player, such
+	// that a slow handler does not delay other handlers.
+	//
+	// When disabled each status update is delivered to each handler in a new
+	// goroutine, and may be received out of order.
+	Ordered bool
+
+	// QueueSize is the number of status updates buffered for each handler
+	// and player. Defaults to 16.
+	QueueSize int
+
+	// Policy configures what to do when a handler falls behind and its queue
+	// is full. Using Block will stop reading status packets from the network
+	// until the handler has caught up. Using Coalesce only keeps the latest
+	// status update pending for each handler and player, regardless of the
+	// QueueSize, and every replaced status update is counted as dropped.
+	Policy DropPolicy
+}
+
+// statusQueue delivers status updates for a single player to a handler in the
+// order they were pushed.
+type statusQueue struct {
+	handler   StatusHandler
+	size      int
+	policy    DropPolicy
+	onDropped func(count int)
+
+	lock    sync.Mutex
+	ready   *sync.Cond
+	items   []*CDJStatus
+	running bool
+	closed  bool
+}
+
+// push adds a status update to the queue, applying the drop policy if the
+// queue is full.
+func (q *statusQueue) push(s *CDJStatus) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.closed {
+		return
+	}
+
+	// Coalescing replaces the pending status update with the latest
+	if q.policy == Coalesce && len(q.items) > 0 {
+		q.onDropped(len(q.items))
+		q.items = append(q.items[:0], s)
+		return
+	}
+
+	if len(q.items) >= q.size {
+		switch q.policy {
+		case Block:
+			for len(q.items) >= q.size && !q.closed {
+				q.ready.Wait()
+			}
+		case DropOldest:
+			q.items = q.items[1:]
+			q.onDropped(1)
+		default:
+			q.onDropped(1)
+			return
+		}
+	}
+
+	if q.closed {
+		return
+	}
+
+	q.items = append(q.items, s)
+
+	if !q.running {
+		q.running = true
+		go q.run()
+	}
+}
+
+// run delivers queued status updates to the handler until the queue is empty.
+func (q *statusQueue) run() {
+	for {
+		q.lock.Lock()
+
+		if len(q.items) == 0 || q.closed {
+			q.running = false
+			q.lock.Unlock()
+			return
+		}
+
+		status := q.items[0]
+		q.items = q.items[1:]
+		q.ready.Broadcast()
+		q.lock.Unlock()
+
+		q.handler.OnStatusUpdate(status)
+	}
+}
+
+// close stops the queue from delivering any further status updates.
+func (q *statusQueue) close() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.closed = true
+	q.items = nil
+	q.ready.Broadcast()
+}
+
+func newStatusQueue(h StatusHandler, config DispatchConfig, onDropped func(int)) *statusQueue {
+	size := config.QueueSize
+	if size <= 0 {
+		size = defaultQueueSize
+	}
+
+	q := &statusQueue{
+		handler:   h,
+		size:      size,
+		policy:    config.Policy,
+		onDropped: onDropped,
+	}
+
+	q.ready = sync.NewCond(&q.lock)
+
+	return q
+}
+
+// queueKey identifies the queue for a handler and player.
+type queueKey struct {
+	handlerID uint64
+	playerID  DeviceID
+}
+
+// orderedDispatcher delivers status updates to handlers using a statusQueue
+// for each handler and player.
+type orderedDispatcher struct {
+	config DispatchConfig
+
+	lock    sync.Mutex
+	queues  map[queueKey]*statusQueue
+	dropped map[DeviceID]*uint64
+	closed  bool
+}
+
+// dispatch delivers the status update to each handler.
+func (d *orderedDispatcher) dispatch(handlers []handlerEntry, s *CDJStatus) {
+	d.lock.Lock()
+
+	if d.closed {
+		d.lock.Unlock()
+		return
+	}
+
+	active := make(map[uint64]bool, len(handlers))
+	queues := make([]*statusQueue, 0, len(handlers))
+
+	for _, entry := range handlers {
+		active[entry.id] = true
+
+		key := queueKey{handlerID: entry.id, playerID: s.PlayerID}
+
+		queue, ok := d.queues[key]
+		if !ok {
+			queue = newStatusQueue(entry.handler.(StatusHandler), d.config, d.dropCounter(s.PlayerID))
+			d.queues[key] = queue
+		}
+
+		queues = append(queues, queue)
+	}
+
+	// Cleanup queues of handlers that have unsubscribed
+	for key, queue := range d.queues {
+		if !active[key.handlerID] {
+			queue.close()
+			delete(d.queues, key)
+		}
+	}
+
+	d.lock.Unlock()
+
+	// Pushing may block depending on the policy, do not hold the lock
+	for _, queue := range queues {
+		queue.push(s)
+	}
+}
+
+// dropCounter returns a function which counts dropped status updates for the
+// player. The lock must be held.
+func (d *orderedDispatcher) dropCounter(id DeviceID) func(int) {
+	counter, ok := d.dropped[id]
+	if !ok {
+		counter = new(uint64)
+		d.dropped[id] = counter
+	}
+
+	return func(count int) {
+		atomic.AddUint64(counter, uint64(count))
+	}
+}
+
+// droppedUpdates returns the number of dropped status updates for each player.
+func (d *orderedDispatcher) droppedUpdates() map[DeviceID]uint64 {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	dropped := make(map[DeviceID]uint64, len(d.dropped))
+
+	for id, counter := range d.dropped {
+		dropped[id] = atomic.LoadUint64(counter)
+	}
+
+	return dropped
+}
+
+// close stops all queues from delivering further status updates.
+func (d *orderedDispatcher) close() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.closed = true
+
+	for key, queue := range d.queues {
+		queue.close()
+		delete(d.queues, key)
+	}
+}
+
+func newOrderedDispatcher(config DispatchConfig) *orderedDispatcher {
+	return &orderedDispatcher{
+		config:  config,
+		queues:  map[queueKey]*statusQueue{},
+		dropped: map[DeviceID]*uint64{},
+	}
+}
@@ -0,0 +1,82 @@
+package prolink
+
+import (
+	"fmt"
+	"net"
+)
+
+// ErrorSource identifies the part of the network an error originated from.
+type ErrorSource string
+
+// Defined error sources.
+const (
+	ErrorSourceAnnouncer     ErrorSource = "announcer"
+	ErrorSourceDeviceManager ErrorSource = "device_manager"
+	ErrorSourceStatusMonitor ErrorSource = "status_monitor"
+	ErrorSourceBeatMonitor   ErrorSource = "beat_monitor"
+	ErrorSourceRemoteDB      ErrorSource = "remote_db"
+	ErrorSourceReplay        ErrorSource = "replay"
+	ErrorSourceRecorder      ErrorSource = "recorder"
+)
+
+// NetworkError describes an error that occurred while communicating with the
+// PRO DJ LINK network in the background, such as failing to read or parse a
+// packet. These errors are reported using Config.OnError.
+type NetworkError struct {
+	Source ErrorSource
+
+	// Addr is the address of the remote device involved in the error. This
+	// may be nil when the remote device is not known.
+	Addr net.Addr
+
+	// Packet is the raw packet that caused the error. This may be nil when
+	// the error did not occur while handling a packet.
+	Packet []byte
+
+	Err error
+}
+
+// Error implements the error interface.
+func (e *NetworkError) Error() string {
+	if e.Addr == nil {
+		return fmt.Sprintf("%s: %s", e.Source, e.Err)
+	}
+
+	return fmt.Sprintf("%s [%s]: %s", e.Source, e.Addr, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *NetworkError) Unwrap() error {
+	return e.Err
+}
+
+// errorReporter is used by background tasks to report errors, given the
+// remote address and packet involved in the error.
+type errorReporter func(addr net.Addr, packet []byte, err error)
+
+// newErrorReporter constructs an errorReporter for the error source which will
+// call the handler with a NetworkError. Errors are given to the handler in the
+// order they were reported, without blocking the reporter. If no handler is
+// provided errors will be discarded.
+func newErrorReporter(source ErrorSource, handler func(*NetworkError)) errorReporter {
+	queue := &eventQueue{}
+
+	return func(addr net.Addr, packet []byte, err error) {
+		if handler == nil {
+			return
+		}
+
+		netErr := &NetworkError{
+			Source: source,
+			Addr:   addr,
+			Err:    err,
+		}
+
+		// Packet buffers are reused, keep a copy of the packet
+		if packet != nil {
+			netErr.Packet = append([]byte(nil), packet...)
+		}
+
+		queue.push(func() { handler(netErr) })
+	}
+}
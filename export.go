@@ -0,0 +1,318 @@
+package prolink
+
+import (
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.evanpurkhiser.com/prolink/anlz"
+	"go.evanpurkhiser.com/prolink/pdb"
+)
+
+// ErrTrackNotFound is returned by ExportDB when the track is not in the
+// export database.
+var ErrTrackNotFound = fmt.Errorf("The track is not in the export database")
+
+// exportDBPath is the path of the export database relative to the root of
+// the media.
+const exportDBPath = "PIONEER/rekordbox/export.pdb"
+
+// highResArtworkSuffix is appended to the name of artwork thumbnails to find
+// the high resolution variant of the artwork.
+const highResArtworkSuffix = "_m"
+
+// ExportDB looks up track metadata in the rekordbox export database of USB or
+// SD media which has been mounted or copied locally. This is much faster than
+// querying the RemoteDB, and works for players which refuse connections.
+type ExportDB struct {
+	root string
+	db   *pdb.Database
+}
+
+// OpenExportDB reads the export database of the media mounted or copied to
+// the root directory.
+func OpenExportDB(root string) (*ExportDB, error) {
+	db, err := pdb.Open(filepath.Join(root, filepath.FromSlash(exportDBPath)))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read export database: %s", err)
+	}
+
+	return &ExportDB{root: root, db: db}, nil
+}
+
+// Database returns the parsed export database, which may be used to browse
+// the playlists and history of the media.
+func (e *ExportDB) Database() *pdb.Database {
+	return e.db
+}
+
+// GetTrack looks up the track of the query. The export database only
+// describes the media it was read from, the DeviceID and Slot of the query
+// are not used.
+func (e *ExportDB) GetTrack(q *TrackQuery) (*Track, error) {
+	row, ok := e.db.Tracks[q.TrackID]
+	if !ok {
+		return nil, ErrTrackNotFound
+	}
+
+	track := &Track{
+		ID:         row.ID,
+		Path:       row.FilePath,
+		Title:      row.Title,
+		Comment:    row.Comment,
+		BPM:        float32(row.Tempo) / 100,
+		Rating:     row.Rating,
+		Color:      TrackColor(row.ColorID),
+		Year:       row.Year,
+		Length:     row.Duration,
+		BitRate:    row.BitRate,
+		SampleRate: row.SampleRate,
+		FileSize:   uint64(row.FileSize),
+	}
+
+	if a, ok := e.db.Artists[row.ArtistID]; ok {
+		track.Artist = a.Name
+	}
+
+	if a, ok := e.db.Artists[row.OriginalArtistID]; ok {
+		track.OriginalArtist = a.Name
+	}
+
+	if a, ok := e.db.Artists[row.RemixerID]; ok {
+		track.Remixer = a.Name
+	}
+
+	if a, ok := e.db.Albums[row.AlbumID]; ok {
+		track.Album = a.Name
+	}
+
+	if g, ok := e.db.Genres[row.GenreID]; ok {
+		track.Genre = g.Name
+	}
+
+	if l, ok := e.db.Labels[row.LabelID]; ok {
+		track.Label = l.Name
+	}
+
+	if k, ok := e.db.Keys[row.KeyID]; ok {
+		track.Key = k.Name
+	}
+
+	if date, err := time.Parse(dateAddedFormat, row.DateAdded); err == nil {
+		track.DateAdded = date
+	}
+
+	if err := e.readArtwork(q, row, track); err != nil {
+		return nil, err
+	}
+
+	return track, nil
+}
+
+// readArtwork reads the artwork of the track from the media. Artwork missing
+// from the media is ignored, as the artwork directory may not be copied along
+// with the export database.
+func (e *ExportDB) readArtwork(q *TrackQuery, row *pdb.Track, track *Track) error {
+	artwork, ok := e.db.Artwork[row.ArtworkID]
+	if !ok {
+		return nil
+	}
+
+	path := filepath.Join(e.root, filepath.FromSlash(artwork.Path))
+
+	if q.HighResArtwork {
+		ext := filepath.Ext(path)
+		data, err := os.ReadFile(strings.TrimSuffix(path, ext) + highResArtworkSuffix + ext)
+
+		if err == nil {
+			track.Artwork = data
+			track.HighResArtwork = true
+
+			return nil
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("Failed to read artwork: %s", err)
+	}
+
+	track.Artwork = data
+
+	return nil
+}
+
+// analysisFile reads the analysis file of the track with the extension, DAT,
+// EXT or 2EX. ErrUnavailable is returned when the track has not been analyzed
+// or the analysis file is missing from the media.
+func (e *ExportDB) analysisFile(q *TrackQuery, ext string) (*anlz.File, error) {
+	row, ok := e.db.Tracks[q.TrackID]
+	if !ok {
+		return nil, ErrTrackNotFound
+	}
+
+	if row.AnalyzePath == "" {
+		return nil, ErrUnavailable
+	}
+
+	path := strings.TrimSuffix(row.AnalyzePath, filepath.Ext(row.AnalyzePath)) + "." + ext
+
+	file, err := anlz.Open(filepath.Join(e.root, filepath.FromSlash(path)))
+	if os.IsNotExist(err) {
+		return nil, ErrUnavailable
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read analysis file: %s", err)
+	}
+
+	return file, nil
+}
+
+// analysisError translates errors decoding a tag of an analysis file.
+func analysisError(err error) error {
+	if err == anlz.ErrTagNotFound {
+		return ErrUnavailable
+	}
+
+	return fmt.Errorf("Failed to read analysis file: %s", err)
+}
+
+// GetBeatGrid reads the beat grid of the track from its analysis file.
+// ErrUnavailable is returned when the track has not been analyzed.
+func (e *ExportDB) GetBeatGrid(q *TrackQuery) (*BeatGrid, error) {
+	file, err := e.analysisFile(q, "DAT")
+	if err != nil {
+		return nil, err
+	}
+
+	beats, err := file.BeatGrid()
+	if err != nil {
+		return nil, analysisError(err)
+	}
+
+	grid := &BeatGrid{Beats: make([]GridBeat, len(beats))}
+	for i, b := range beats {
+		grid.Beats[i] = GridBeat{
+			Time:          b.Time,
+			BeatInMeasure: uint8(b.BeatInMeasure),
+			BPM:           float32(b.Tempo) / 100,
+		}
+	}
+
+	return grid, nil
+}
+
+// GetCueList reads the memory points, hot cues and saved loops of the track
+// from its analysis files. The extended cue list is used when the track was
+// exported by a version of rekordbox which writes it. ErrUnavailable is
+// returned when the track has not been analyzed.
+func (e *ExportDB) GetCueList(q *TrackQuery) (*CueList, error) {
+	var lists []*anlz.CueList
+
+	for _, ext := range []string{"EXT", "DAT"} {
+		file, err := e.analysisFile(q, ext)
+		if err == ErrUnavailable {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		lists, err = file.CueLists()
+		if err == anlz.ErrTagNotFound {
+			continue
+		}
+
+		if err != nil {
+			return nil, analysisError(err)
+		}
+
+		break
+	}
+
+	if lists == nil {
+		return nil, ErrUnavailable
+	}
+
+	list := &CueList{Cues: []Cue{}, Extended: lists[0].Extended}
+
+	for _, l := range lists {
+		for _, c := range l.Cues {
+			cue := Cue{
+				HotCue:   uint8(c.HotCue),
+				Position: c.Time,
+				Loop:     c.Loop,
+				LoopEnd:  c.LoopTime,
+				Comment:  c.Comment,
+				ColorID:  c.ColorID,
+			}
+
+			if c.HasColor {
+				cue.Color = color.RGBA{c.Red, c.Green, c.Blue, 0xff}
+			}
+
+			list.Cues = append(list.Cues, cue)
+		}
+	}
+
+	list.sortCues()
+
+	return list, nil
+}
+
+// getWaveform reads the waveform from the analysis file of the track.
+func (e *ExportDB) getWaveform(q *TrackQuery, style WaveformStyle, detail bool) (*Waveform, error) {
+	tag, ext := (&Waveform{Style: style, Detail: detail}).anlzTag()
+
+	file, err := e.analysisFile(q, ext)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := file.Waveform(tag)
+	if err != nil {
+		return nil, analysisError(err)
+	}
+
+	return newWaveform(style, detail, w.Entries, w.EntrySize), nil
+}
+
+// GetWaveformPreview reads the waveform preview of the track in the style
+// from its analysis files. ErrUnavailable is returned when the track has not
+// been analyzed, or the style is not available.
+func (e *ExportDB) GetWaveformPreview(q *TrackQuery, style WaveformStyle) (*Waveform, error) {
+	return e.getWaveform(q, style, false)
+}
+
+// GetWaveformDetail reads the detailed waveform of the track in the style
+// from its analysis files. ErrUnavailable is returned when the track has not
+// been analyzed, or the style is not available.
+func (e *ExportDB) GetWaveformDetail(q *TrackQuery, style WaveformStyle) (*Waveform, error) {
+	return e.getWaveform(q, style, true)
+}
+
+// GetSongStructure reads the phrase analysis of the track from its analysis
+// files. ErrUnavailable is returned when the track has not been analyzed, or
+// was analyzed by a version of rekordbox which does not analyze phrases.
+func (e *ExportDB) GetSongStructure(q *TrackQuery) (*anlz.SongStructure, error) {
+	file, err := e.analysisFile(q, "EXT")
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := file.SongStructure()
+	if err != nil {
+		return nil, analysisError(err)
+	}
+
+	return s, nil
+}
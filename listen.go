@@ -1,26 +1,36 @@
 package prolink
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net"
 
 	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
 )
 
-// captureListener implements io.Reader, providing the ability to capture
+// PacketListener receives packets from the PRO DJ LINK network, reporting the
+// address each packet was sent from.
+type PacketListener interface {
+	ReadFrom(p []byte) (int, net.Addr, error)
+	io.Closer
+}
+
+// captureListener implements PacketListener, providing the ability to capture
 // status packets using pcap, instead of binding to the interface itself.
 //
 // This allows the software to run along side other programs that listen for
 // status packets (such as rekordbox).
 type captureListener struct {
+	handle *pcap.Handle
 	source *gopacket.PacketSource
 }
 
-// Read implements the io.Reader interface. This method will read a status
-// packet directly off the interface using packet capturing.
-func (cl *captureListener) Read(p []byte) (int, error) {
+// ReadFrom implements the PacketListener interface. This method will read a
+// status packet directly off the interface using packet capturing.
+func (cl *captureListener) ReadFrom(p []byte) (int, net.Addr, error) {
 	for packet := range cl.source.Packets() {
 		appLayer := packet.ApplicationLayer()
 		if appLayer == nil {
@@ -30,10 +40,42 @@ func (cl *captureListener) Read(p []byte) (int, error) {
 		data := appLayer.Payload()
 		copy(p, data)
 
-		return len(data), nil
+		return len(data), packetSourceAddr(packet), nil
+	}
+
+	// The packet source is exhausted once the handle has been closed
+	return 0, nil, io.EOF
+}
+
+// Read implements the io.Reader interface.
+func (cl *captureListener) Read(p []byte) (int, error) {
+	n, _, err := cl.ReadFrom(p)
+
+	return n, err
+}
+
+// Close implements the io.Closer interface. This stops capturing packets on
+// the interface.
+func (cl *captureListener) Close() error {
+	cl.handle.Close()
+
+	return nil
+}
+
+// packetSourceAddr determines the UDP address a captured packet was sent from.
+func packetSourceAddr(packet gopacket.Packet) net.Addr {
+	ipLayer, ok := packet.NetworkLayer().(*layers.IPv4)
+	if !ok {
+		return nil
 	}
 
-	return 0, nil
+	addr := &net.UDPAddr{IP: ipLayer.SrcIP}
+
+	if udpLayer, ok := packet.TransportLayer().(*layers.UDP); ok {
+		addr.Port = int(udpLayer.SrcPort)
+	}
+
+	return addr
 }
 
 // newCaptureListener attempts to create a captureListener. In the case where
@@ -53,27 +95,51 @@ func newCaptureListener(iface *net.Interface, addr *net.UDPAddr) (*captureListen
 	src := gopacket.NewPacketSource(handle, handle.LinkType())
 
 	listener := captureListener{
+		handle: handle,
 		source: src,
 	}
 
 	return &listener, nil
 }
 
-// openListener crates a status listener connection (returned as an io.Reader).
-// If sniff is enabled we will attempt to listen on the interface using packet
-// capturing, otherwise, we will simply directly bind to the interface.
-func openListener(iface *net.Interface, addr *net.UDPAddr, sniff bool) (io.Reader, error) {
+// openListener crates a status listener connection (returned as a
+// PacketListener). If sniff is enabled we will attempt to listen on the
+// interface using packet capturing, otherwise, we will simply directly bind to
+// the interface.
+func openListener(iface *net.Interface, addr *net.UDPAddr, sniff bool) (PacketListener, error) {
+	var captureErr error
+
 	if sniff {
 		captureListener, err := newCaptureListener(iface, addr)
 		if err == nil {
 			return captureListener, nil
 		}
+
+		captureErr = err
 	}
 
-	listenerConn, err := net.ListenUDP("udp", listenerAddr)
+	listenerConn, err := listenUDP(iface, addr)
 	if err == nil {
 		return listenerConn, nil
 	}
 
-	return nil, fmt.Errorf("Cannot capture or bind to interface to listen")
+	if captureErr != nil {
+		return nil, fmt.Errorf("Cannot capture (%s) or bind to interface to listen: %w", captureErr, err)
+	}
+
+	return nil, fmt.Errorf("Cannot bind to interface to listen: %w", err)
+}
+
+// listenUDP binds a UDP connection to the address on the given network
+// interface. The socket is bound such that multiple networks may listen on the
+// same port across different network interfaces.
+func listenUDP(iface *net.Interface, addr *net.UDPAddr) (*net.UDPConn, error) {
+	lc := net.ListenConfig{Control: listenControl(iface)}
+
+	conn, err := lc.ListenPacket(context.Background(), "udp4", addr.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return conn.(*net.UDPConn), nil
 }
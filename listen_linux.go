@@ -0,0 +1,45 @@
+package prolink
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// listenControl configures sockets to be reused across network interfaces.
+// The socket is bound to the device so that only packets arriving on the
+// interface are received.
+//
+// Binding to a device requires the CAP_NET_RAW capability on kernels older
+// than 5.7. Without it the socket is left unbound and is no longer reused, so
+// a single network may still be connected to, while connecting a network on
+// another interface fails as the ports are already in use. Otherwise networks
+// on different interfaces would receive each others packets.
+func listenControl(iface *net.Interface) func(string, string, syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+
+		err := c.Control(func(fd uintptr) {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+			if sockErr != nil {
+				return
+			}
+
+			sockErr = syscall.BindToDevice(int(fd), iface.Name)
+			if sockErr == syscall.EPERM {
+				sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 0)
+				return
+			}
+
+			if sockErr != nil {
+				sockErr = fmt.Errorf("Cannot bind socket to interface %s: %s", iface.Name, sockErr)
+			}
+		})
+
+		if err != nil {
+			return err
+		}
+
+		return sockErr
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package prolink
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenControl is a no-op on platforms where sockets cannot be bound to a
+// specific network interface. Only a single network may be connected to at
+// once on these platforms, connecting a second network fails as the ports are
+// already in use.
+func listenControl(iface *net.Interface) func(string, string, syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,408 @@
+package prolink
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Number of packets that may be queued on each memory connection before
+// injecting packets blocks.
+const memoryQueueSize = 256
+
+// memoryPacket is a packet queued on a memoryConn.
+type memoryPacket struct {
+	data []byte
+	addr net.Addr
+}
+
+// memoryConn implements net.PacketConn using a queue of injected packets.
+// Packets written to the connection are given to the written channel, if the
+// channel is full the packet is discarded.
+type memoryConn struct {
+	addr    net.Addr
+	packets chan memoryPacket
+	written chan<- []byte
+
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	lock     sync.Mutex
+	deadline time.Time
+
+	// unhandled counts injected packets which have not been handled. A read
+	// packet is handled once the reader returns to read the next packet.
+	unhandled int
+	reading   bool
+}
+
+// ReadFrom implements the net.PacketConn interface.
+func (c *memoryConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	c.lock.Lock()
+	deadline := c.deadline
+
+	// The previously read packet has been handled
+	if c.reading {
+		c.reading = false
+		c.unhandled--
+	}
+
+	c.lock.Unlock()
+
+	var timeout <-chan time.Time
+
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+
+		timeout = timer.C
+	}
+
+	select {
+	case packet := <-c.packets:
+		c.lock.Lock()
+		c.reading = true
+		c.lock.Unlock()
+
+		return copy(p, packet.data), packet.addr, nil
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	case <-timeout:
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+// WriteTo implements the net.PacketConn interface.
+func (c *memoryConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+
+	if c.written == nil {
+		return len(p), nil
+	}
+
+	select {
+	case c.written <- append([]byte(nil), p...):
+	default:
+	}
+
+	return len(p), nil
+}
+
+// Close implements the net.PacketConn interface.
+func (c *memoryConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+
+	return nil
+}
+
+// LocalAddr implements the net.PacketConn interface.
+func (c *memoryConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+// SetDeadline implements the net.PacketConn interface. Writes never block,
+// only the read deadline is used.
+func (c *memoryConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+// SetReadDeadline implements the net.PacketConn interface. The deadline is
+// applied to subsequent calls to ReadFrom.
+func (c *memoryConn) SetReadDeadline(t time.Time) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.deadline = t
+
+	return nil
+}
+
+// SetWriteDeadline implements the net.PacketConn interface.
+func (c *memoryConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// isClosed reports if the connection has been closed.
+func (c *memoryConn) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// pending reports the number of injected packets which have not yet been read
+// and handled.
+func (c *memoryConn) pending() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.unhandled
+}
+
+// inject queues a packet to be read from the connection. This blocks while
+// the queue is full, unless the connection is closed.
+func (c *memoryConn) inject(packet []byte, from net.Addr) {
+	c.lock.Lock()
+	c.unhandled++
+	c.lock.Unlock()
+
+	select {
+	case c.packets <- memoryPacket{data: append([]byte(nil), packet...), addr: from}:
+	case <-c.closed:
+		c.lock.Lock()
+		c.unhandled--
+		c.lock.Unlock()
+	}
+}
+
+func newMemoryConn(addr net.Addr, written chan<- []byte) *memoryConn {
+	return &memoryConn{
+		addr:    addr,
+		packets: make(chan memoryPacket, memoryQueueSize),
+		written: written,
+		closed:  make(chan struct{}),
+	}
+}
+
+// memoryListener implements net.Listener, accepting connections dialed using
+// MemoryTransport.Dial.
+type memoryListener struct {
+	addr    net.Addr
+	conns   chan net.Conn
+	onClose func()
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// Accept implements the net.Listener interface.
+func (l *memoryListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close implements the net.Listener interface.
+func (l *memoryListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		l.onClose()
+	})
+
+	return nil
+}
+
+// Addr implements the net.Listener interface.
+func (l *memoryListener) Addr() net.Addr {
+	return l.addr
+}
+
+// memoryStream is one end of an in-memory stream connection. Like a TCP
+// connection, writing succeeds after the remote end has closed the connection,
+// discarding the data, while reading reports io.EOF.
+type memoryStream struct {
+	net.Conn
+
+	lock   sync.Mutex
+	closed bool
+}
+
+// Write implements the net.Conn interface.
+func (c *memoryStream) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if err == io.ErrClosedPipe && !c.closed {
+		return len(b), nil
+	}
+
+	return n, err
+}
+
+// Close implements the net.Conn interface.
+func (c *memoryStream) Close() error {
+	c.lock.Lock()
+	c.closed = true
+	c.lock.Unlock()
+
+	return c.Conn.Close()
+}
+
+// newMemoryStream constructs both ends of an in-memory stream connection.
+func newMemoryStream() (client, server net.Conn) {
+	client, server = net.Pipe()
+
+	return &memoryStream{Conn: client}, &memoryStream{Conn: server}
+}
+
+// MemoryTransport is a Transport which does not use any sockets, allowing the
+// network to be used without a real PRO DJ LINK network. Packets are injected
+// directly using InjectAnnounce, InjectBeat and InjectStatus, and the packets
+// sent by the virtual CDJ may be read using Announced. Remote database
+// servers may be served using Listen.
+//
+// This is primarily useful for testing code built on the DeviceManager,
+// CDJStatusMonitor and RemoteDB. Configure Config.VirtualCDJID to avoid
+// automatically claiming a device ID when connecting, which takes a few
+// seconds.
+type MemoryTransport struct {
+	// IP and MacAddr are the addresses the virtual CDJ is announced with.
+	IP      net.IP
+	MacAddr net.HardwareAddr
+
+	announced chan []byte
+
+	lock      sync.Mutex
+	announce  *memoryConn
+	beat      *memoryConn
+	status    *memoryConn
+	listeners map[string]*memoryListener
+}
+
+// conn returns the open connection for the given port, creating a new
+// connection if it has not yet been opened or was closed.
+func (t *MemoryTransport) conn(conn **memoryConn, port int, written chan<- []byte) *memoryConn {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if *conn == nil || (*conn).isClosed() {
+		*conn = newMemoryConn(&net.UDPAddr{IP: t.IP, Port: port}, written)
+	}
+
+	return *conn
+}
+
+// LocalAddr implements the Transport interface.
+func (t *MemoryTransport) LocalAddr() (net.IP, net.HardwareAddr, error) {
+	return t.IP, t.MacAddr, nil
+}
+
+// ListenAnnounce implements the Transport interface.
+func (t *MemoryTransport) ListenAnnounce() (net.PacketConn, error) {
+	return t.conn(&t.announce, announceAddr.Port, t.announced), nil
+}
+
+// ListenBeat implements the Transport interface.
+func (t *MemoryTransport) ListenBeat() (PacketListener, error) {
+	return t.conn(&t.beat, beatAddr.Port, nil), nil
+}
+
+// ListenStatus implements the Transport interface.
+func (t *MemoryTransport) ListenStatus() (PacketListener, error) {
+	return t.conn(&t.status, listenerAddr.Port, nil), nil
+}
+
+// Dial implements the Transport interface. The connection will be accepted by
+// the listener registered for the address using Listen.
+func (t *MemoryTransport) Dial(address string) (net.Conn, error) {
+	addr, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	t.lock.Lock()
+	listener, ok := t.listeners[addr.String()]
+	t.lock.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("Connection refused by %s", addr)
+	}
+
+	client, server := newMemoryStream()
+
+	select {
+	case listener.conns <- server:
+		return client, nil
+	case <-listener.closed:
+		return nil, fmt.Errorf("Connection refused by %s", addr)
+	}
+}
+
+// Listen registers a listener for connections dialed to the address, given as
+// a host:port pair. The listener should be closed when no longer needed.
+func (t *MemoryTransport) Listen(address string) (net.Listener, error) {
+	addr, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	key := addr.String()
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if _, ok := t.listeners[key]; ok {
+		return nil, fmt.Errorf("Address %s is already in use", addr)
+	}
+
+	listener := &memoryListener{
+		addr:   addr,
+		conns:  make(chan net.Conn, 16),
+		closed: make(chan struct{}),
+	}
+
+	listener.onClose = func() {
+		t.lock.Lock()
+		defer t.lock.Unlock()
+
+		if t.listeners[key] == listener {
+			delete(t.listeners, key)
+		}
+	}
+
+	t.listeners[key] = listener
+
+	return listener, nil
+}
+
+// InjectAnnounce delivers a packet to the announce port, as if it was sent
+// from the given address. The address may be nil. This blocks while the
+// queue of unread packets is full.
+func (t *MemoryTransport) InjectAnnounce(packet []byte, from net.Addr) {
+	t.conn(&t.announce, announceAddr.Port, t.announced).inject(packet, from)
+}
+
+// InjectBeat delivers a packet to the beat port, as if it was sent from the
+// given address. The address may be nil.
+func (t *MemoryTransport) InjectBeat(packet []byte, from net.Addr) {
+	t.conn(&t.beat, beatAddr.Port, nil).inject(packet, from)
+}
+
+// InjectStatus delivers a packet to the status port, as if it was sent from
+// the given address. The address may be nil.
+func (t *MemoryTransport) InjectStatus(packet []byte, from net.Addr) {
+	t.conn(&t.status, listenerAddr.Port, nil).inject(packet, from)
+}
+
+// Announced returns a channel of packets sent on the announce port, such as
+// the device ID claim and keep alive packets of the virtual CDJ. Packets are
+// discarded when the channel is not read from.
+func (t *MemoryTransport) Announced() <-chan []byte {
+	return t.announced
+}
+
+// NewMemoryTransport constructs a MemoryTransport. The virtual CDJ will be
+// announced using a link-local IP address, which may be changed before
+// connecting.
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{
+		IP:        net.IPv4(169, 254, 1, 1).To4(),
+		MacAddr:   net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01},
+		announced: make(chan []byte, memoryQueueSize),
+		listeners: map[string]*memoryListener{},
+	}
+}
@@ -0,0 +1,194 @@
+package prolink_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"go.evanpurkhiser.com/prolink"
+)
+
+// testTimeout is how long tests wait for events to be delivered.
+const testTimeout = 2 * time.Second
+
+// connectMemory connects to a network using a MemoryTransport, with the
+// virtual CDJ using device ID 5.
+func connectMemory(t *testing.T, config prolink.Config) (*prolink.Network, *prolink.MemoryTransport) {
+	t.Helper()
+
+	transport := prolink.NewMemoryTransport()
+
+	config.Transport = transport
+	config.VirtualCDJID = 5
+
+	network, err := prolink.Connect(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { network.Close() })
+
+	return network, transport
+}
+
+// testDevice constructs a CDJ with the device ID.
+func testDevice(id prolink.DeviceID) *prolink.Device {
+	return &prolink.Device{
+		Name:    "CDJ-2000",
+		ID:      id,
+		Type:    prolink.DeviceTypeCDJ,
+		MacAddr: net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, byte(id)},
+		IP:      net.IPv4(169, 254, 1, byte(id)).To4(),
+	}
+}
+
+// announce injects the keep alive packet of the device.
+func announce(t *testing.T, transport *prolink.MemoryTransport, dev *prolink.Device) {
+	t.Helper()
+
+	packet, err := dev.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport.InjectAnnounce(packet, &net.UDPAddr{IP: dev.IP, Port: 50000})
+}
+
+func TestDeviceManagerEvents(t *testing.T) {
+	network, transport := connectMemory(t, prolink.Config{})
+	dm := network.DeviceManager()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The zero configuration buffers events
+	events := dm.DeviceEvents(ctx, prolink.ChannelConfig{})
+
+	conflicts := make(chan *prolink.DeviceConflict, 1)
+	dm.OnDeviceConflict(prolink.DeviceConflictListenerFunc(func(c *prolink.DeviceConflict) {
+		conflicts <- c
+	}))
+
+	announce(t, transport, testDevice(2))
+	announce(t, transport, testDevice(3))
+
+	for _, id := range []prolink.DeviceID{2, 3} {
+		select {
+		case e := <-events:
+			if e.Type != prolink.DeviceAdded || e.Device.ID != id {
+				t.Errorf("got %s event for device %d, want added event for device %d", e.Type, e.Device.ID, id)
+			}
+		case <-time.After(testTimeout):
+			t.Fatalf("timed out waiting for device %d to be added", id)
+		}
+	}
+
+	if devices := dm.ActiveDeviceMap(); len(devices) != 2 || devices[2].Name != "CDJ-2000" {
+		t.Errorf("got active devices %v", devices)
+	}
+
+	// A device announcing the ID of the virtual CDJ conflicts with it
+	conflicting := testDevice(5)
+	conflicting.MacAddr = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x99}
+	announce(t, transport, conflicting)
+
+	select {
+	case c := <-conflicts:
+		if !c.IsVirtualCDJ || c.ID != 5 {
+			t.Errorf("got conflict %+v, want a conflict with the virtual CDJ", c)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for the device conflict")
+	}
+
+	cancel()
+
+	// The channel is closed once the context is done
+	for range events {
+	}
+}
+
+func TestCDJStatusMonitor(t *testing.T) {
+	tests := []struct {
+		name     string
+		dispatch prolink.DispatchConfig
+	}{
+		{"unordered", prolink.DispatchConfig{}},
+		{"ordered", prolink.DispatchConfig{Ordered: true, QueueSize: 8, Policy: prolink.Block}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			network, transport := connectMemory(t, prolink.Config{StatusDispatch: tt.dispatch})
+			sm := network.CDJStatusMonitor()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			updates := sm.StatusUpdates(ctx, prolink.ChannelConfig{Buffer: 8})
+
+			handled := make(chan *prolink.CDJStatus, 8)
+			sm.OnStatusUpdate(prolink.StatusHandlerFunc(func(s *prolink.CDJStatus) {
+				handled <- s
+			}))
+
+			from := &net.UDPAddr{IP: testDevice(2).IP, Port: 50002}
+
+			for beat := uint32(1); beat <= 4; beat++ {
+				status := &prolink.CDJStatus{
+					PlayerID:      2,
+					TrackID:       42,
+					TrackDevice:   2,
+					TrackSlot:     prolink.TrackSlotUSB,
+					PlayState:     prolink.PlayStatePlaying,
+					TrackBPM:      124,
+					IsOnAir:       true,
+					Beat:          beat,
+					BeatInMeasure: uint8((beat-1)%4 + 1),
+					PacketNum:     beat,
+				}
+
+				packet, err := status.MarshalBinary()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				transport.InjectStatus(packet, from)
+			}
+
+			received := map[uint32]bool{}
+
+			for i := uint32(1); i <= 4; i++ {
+				select {
+				case s := <-updates:
+					if s.PlayerID != 2 || s.TrackID != 42 || s.TrackBPM != 124 || !s.IsOnAir {
+						t.Errorf("got unexpected status %+v", s)
+					}
+
+					// Channel updates are always delivered in order
+					if s.Beat != i {
+						t.Errorf("got beat %d from the channel, want %d", s.Beat, i)
+					}
+				case <-time.After(testTimeout):
+					t.Fatal("timed out waiting for status updates")
+				}
+
+				select {
+				case s := <-handled:
+					if tt.dispatch.Ordered && s.Beat != i {
+						t.Errorf("got beat %d from the handler, want %d", s.Beat, i)
+					}
+
+					received[s.Beat] = true
+				case <-time.After(testTimeout):
+					t.Fatal("timed out waiting for the status handler")
+				}
+			}
+
+			if len(received) != 4 {
+				t.Errorf("handler received beats %v, want 4 beats", received)
+			}
+		})
+	}
+}
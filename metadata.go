@@ -0,0 +1,91 @@
+package prolink
+
+import (
+	"time"
+
+	"go.evanpurkhiser.com/prolink/dbserver"
+)
+
+// TrackColor is the color label assigned to a track in rekordbox.
+type TrackColor uint8
+
+// Known track colors.
+const (
+	TrackColorNone TrackColor = iota
+	TrackColorPink
+	TrackColorRed
+	TrackColorOrange
+	TrackColorYellow
+	TrackColorGreen
+	TrackColorAqua
+	TrackColorBlue
+	TrackColorPurple
+)
+
+var trackColorLabels = map[TrackColor]string{
+	TrackColorNone:   "none",
+	TrackColorPink:   "pink",
+	TrackColorRed:    "red",
+	TrackColorOrange: "orange",
+	TrackColorYellow: "yellow",
+	TrackColorGreen:  "green",
+	TrackColorAqua:   "aqua",
+	TrackColorBlue:   "blue",
+	TrackColorPurple: "purple",
+}
+
+// String returns the name of the color.
+func (c TrackColor) String() string {
+	return trackColorLabels[c]
+}
+
+// dateAddedFormat is the format of the label of ItemDateAdded items.
+const dateAddedFormat = "2006-01-02"
+
+// metadataLabels maps the type of metadata items labeled with a metadata
+// value to the Track field it populates.
+var metadataLabels = map[dbserver.ItemType]func(t *Track) *string{
+	dbserver.ItemTitle:          func(t *Track) *string { return &t.Title },
+	dbserver.ItemArtist:         func(t *Track) *string { return &t.Artist },
+	dbserver.ItemOriginalArtist: func(t *Track) *string { return &t.OriginalArtist },
+	dbserver.ItemRemixer:        func(t *Track) *string { return &t.Remixer },
+	dbserver.ItemAlbum:          func(t *Track) *string { return &t.Album },
+	dbserver.ItemLabel:          func(t *Track) *string { return &t.Label },
+	dbserver.ItemGenre:          func(t *Track) *string { return &t.Genre },
+	dbserver.ItemComment:        func(t *Track) *string { return &t.Comment },
+	dbserver.ItemKey:            func(t *Track) *string { return &t.Key },
+	dbserver.ItemPath:           func(t *Track) *string { return &t.Path },
+}
+
+// setMetadata populates the field of the track described by the metadata
+// item. Items are identified by their type, as the order of the items differs
+// between players and rekordbox.
+func (t *Track) setMetadata(item *dbserver.MenuItem) {
+	kind := item.Type.Base()
+
+	if field, ok := metadataLabels[kind]; ok {
+		*field(t) = item.Label
+		return
+	}
+
+	switch kind {
+	case dbserver.ItemDuration:
+		t.Length = time.Duration(item.ID) * time.Second
+	case dbserver.ItemTempo:
+		t.BPM = float32(item.ID) / 100
+	case dbserver.ItemRating:
+		t.Rating = uint8(item.ID)
+	case dbserver.ItemYear:
+		t.Year = uint16(item.ID)
+	case dbserver.ItemBitRate:
+		t.BitRate = item.ID
+	case dbserver.ItemDateAdded:
+		if date, err := time.Parse(dateAddedFormat, item.Label); err == nil {
+			t.DateAdded = date
+		}
+	}
+
+	if kind >= dbserver.ItemColor && kind <= dbserver.ItemColorPurple {
+		t.Color = TrackColor(kind - dbserver.ItemColor)
+	}
+}
@@ -0,0 +1,111 @@
+package prolink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Length of mixer status packets
+const mixerStatusPacketLen = 0x38
+
+// Packet type byte of mixer status packets
+const mixerStatusType byte = 0x29
+
+// noMasterHandoff is reported as the master handoff target when the mixer is
+// not handing off the tempo master role.
+const noMasterHandoff DeviceID = 0xFF
+
+// MixerStatus represents the state of a DJM mixer on the network.
+type MixerStatus struct {
+	DeviceID      DeviceID
+	BPM           float32
+	Pitch         float32
+	IsMaster      bool
+	BeatInMeasure uint8
+
+	// MasterHandoff is the ID of the device the mixer is handing the tempo
+	// master role off to. This will be zero when no handoff is in progress.
+	MasterHandoff DeviceID
+}
+
+// String returns a string representation of the mixer status.
+func (s *MixerStatus) String() string {
+	statusText := `Status of Mixer %d
+  BPM    %-9s [pitch %2.2f%%]
+  Beat   %-9s [master: %t, handoff to: %d]`
+
+	return fmt.Sprintf(statusText,
+		s.DeviceID,
+		fmt.Sprintf("%2.2f", s.BPM),
+		s.Pitch,
+		fmt.Sprintf("%d/4", s.BeatInMeasure),
+		s.IsMaster,
+		s.MasterHandoff,
+	)
+}
+
+func packetToMixerStatus(p []byte) (*MixerStatus, error) {
+	if !bytes.HasPrefix(p, prolinkHeader) {
+		return nil, fmt.Errorf("Mixer status packet does not start with the expected header")
+	}
+
+	if len(p) < mixerStatusPacketLen {
+		return nil, fmt.Errorf("Mixer status packet is too short (%d bytes)", len(p))
+	}
+
+	status := &MixerStatus{
+		DeviceID:      DeviceID(p[0x21]),
+		BPM:           calcBPM(p[0x2E : 0x2E+2]),
+		Pitch:         calcPitch(p[0x29 : 0x29+3]),
+		IsMaster:      p[0x27]&statusFlagMaster != 0,
+		BeatInMeasure: uint8(p[0x37]),
+	}
+
+	if handoff := DeviceID(p[0x36]); handoff != noMasterHandoff {
+		status.MasterHandoff = handoff
+	}
+
+	return status, nil
+}
+
+// MarshalBinary encodes the status as a mixer status packet, in the same form
+// mixers report their status on the network.
+func (s *MixerStatus) MarshalBinary() ([]byte, error) {
+	p := make([]byte, mixerStatusPacketLen)
+
+	copy(p, prolinkHeader)
+	p[0x0A] = mixerStatusType
+	p[0x20] = 0x01
+	p[0x21] = byte(s.DeviceID)
+	binary.BigEndian.PutUint16(p[0x22:], mixerStatusPacketLen-0x24)
+	p[0x24] = byte(s.DeviceID)
+
+	if s.IsMaster {
+		p[0x27] |= statusFlagMaster
+	}
+
+	copy(p[0x29:], pitchBytes(s.Pitch))
+	copy(p[0x2E:], bpmBytes(s.BPM))
+
+	p[0x36] = byte(noMasterHandoff)
+	if s.MasterHandoff != 0 {
+		p[0x36] = byte(s.MasterHandoff)
+	}
+
+	p[0x37] = s.BeatInMeasure
+
+	return p, nil
+}
+
+// A MixerStatusHandler responds to status updates on a mixer.
+type MixerStatusHandler interface {
+	OnMixerStatus(*MixerStatus)
+}
+
+// The MixerStatusHandlerFunc is an adapter to allow a function to be used as
+// a MixerStatusHandler.
+type MixerStatusHandlerFunc func(*MixerStatus)
+
+// OnMixerStatus implements MixerStatusHandler.
+func (f MixerStatusHandlerFunc) OnMixerStatus(s *MixerStatus) { f(s) }
@@ -2,8 +2,10 @@ package prolink
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 )
 
@@ -23,6 +25,12 @@ var announceAddr = &net.UDPAddr{
 	Port: 50000,
 }
 
+// The UDP address on which beat packets are received.
+var beatAddr = &net.UDPAddr{
+	IP:   net.IPv4zero,
+	Port: 50001,
+}
+
 // The UDP address on which device information is received.
 var listenerAddr = &net.UDPAddr{
 	IP:   net.IPv4zero,
@@ -47,17 +55,17 @@ func getAnnouncePacket(dev *Device) []byte {
 	unknown2 := []byte{0x01, 0x00, 0x00, 0x00}
 
 	parts := [][]byte{
-		prolinkHeader,          // 0x00: 10 byte header
-		[]byte{0x06, 0x00},     // 0x0A: 02 byte announce packet type
-		name,                   // 0x0c: 20 byte device name
-		unknown1,               // 0x20: 04 byte unknown
-		[]byte{byte(dev.ID)},   // 0x24: 01 byte for the player ID
-		[]byte{0x00},           // 0x25: 01 byte unknown
-		dev.MacAddr[:6],        // 0x26: 06 byte mac address
-		dev.IP.To4(),           // 0x2C: 04 byte IP address
-		unknown2,               // 0x30: 04 byte unknown
-		[]byte{byte(dev.Type)}, // 0x34: 01 byte for the player type
-		[]byte{0x00},           // 0x35: 01 byte final padding
+		prolinkHeader,              // 0x00: 10 byte header
+		[]byte{announceType, 0x00}, // 0x0A: 02 byte announce packet type
+		name,                       // 0x0c: 20 byte device name
+		unknown1,                   // 0x20: 04 byte unknown
+		[]byte{byte(dev.ID)},       // 0x24: 01 byte for the player ID
+		[]byte{0x00},               // 0x25: 01 byte unknown
+		dev.MacAddr[:6],            // 0x26: 06 byte mac address
+		dev.IP.To4(),               // 0x2C: 04 byte IP address
+		unknown2,                   // 0x30: 04 byte unknown
+		[]byte{byte(dev.Type)},     // 0x34: 01 byte for the player type
+		[]byte{0x00},               // 0x35: 01 byte final padding
 
 	}
 
@@ -71,7 +79,7 @@ func deviceFromAnnouncePacket(packet []byte) (*Device, error) {
 		return nil, fmt.Errorf("Announce packet does not start with expected header")
 	}
 
-	if packet[0x0A] != 0x06 {
+	if len(packet) < announcePacketLen || packet[0x0A] != announceType {
 		return nil, fmt.Errorf("Packet is not an announce packet")
 	}
 
@@ -104,7 +112,11 @@ func getBroadcastInterface(name string) (*net.Interface, error) {
 			continue
 		}
 
-		if possibleIface.Flags&net.FlagBroadcast != 0 {
+		// The loopback interface may only be used when explicitly named, such
+		// as when connecting to a simulated network.
+		isLoopback := name != "" && possibleIface.Flags&net.FlagLoopback != 0
+
+		if possibleIface.Flags&net.FlagBroadcast != 0 || isLoopback {
 			iface = &possibleIface
 			break
 		}
@@ -135,48 +147,40 @@ func getBroadcastAddress(dev *Device) *net.UDPAddr {
 	return &broadcastAddr
 }
 
-// newVirtualCDJDevice constructs a Device that can be bound to the network
-// interface provided.
-func newVirtualCDJDevice(iface *net.Interface, id DeviceID) (*Device, error) {
-	addrs, err := iface.Addrs()
-	if err != nil {
-		return nil, err
-	}
-
-	var ipAddress *net.IP
-	for _, addr := range addrs {
-		ipNet, ok := addr.(*net.IPNet)
-		if ok && ipNet.IP.To4() != nil && !ipNet.IP.IsLoopback() {
-			ipAddress = &ipNet.IP
-			break
-		}
-	}
-	if ipAddress == nil {
-		return nil, fmt.Errorf("No IPv4 broadcast interface available")
-	}
-
-	virtualCDJ := &Device{
+// newVirtualCDJDevice constructs a Device that can be announced on the network
+// using the given addresses.
+func newVirtualCDJDevice(ip net.IP, mac net.HardwareAddr, id DeviceID) *Device {
+	return &Device{
 		Name:    VirtualCDJName,
 		ID:      id,
 		Type:    DeviceTypeCDJ,
-		MacAddr: iface.HardwareAddr,
-		IP:      *ipAddress,
+		MacAddr: mac,
+		IP:      ip,
 	}
-
-	return virtualCDJ, nil
 }
 
 // startVCDJAnnouncer creates a goroutine that will continually announce a
-// virtual CDJ device on the host network. Returns the Virtual CDJ being
-// announced.
-func startVCDJAnnouncer(vCDJ *Device, announceConn *net.UDPConn) error {
+// virtual CDJ device on the host network. The announcer will stop once the
+// stop channel is closed. Errors sending announce packets are given to the
+// errorReporter.
+func startVCDJAnnouncer(vCDJ *Device, announceConn net.PacketConn, stop <-chan bool, reportError errorReporter) error {
 	broadcastAddrs := getBroadcastAddress(vCDJ)
 	announcePacket := getAnnouncePacket(vCDJ)
 	announceTicker := time.NewTicker(keepAliveInterval)
 
 	go func() {
-		for range announceTicker.C {
-			announceConn.WriteToUDP(announcePacket, broadcastAddrs)
+		defer announceTicker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-announceTicker.C:
+				_, err := announceConn.WriteTo(announcePacket, broadcastAddrs)
+				if err != nil {
+					reportError(broadcastAddrs, announcePacket, err)
+				}
+			}
 		}
 	}()
 
@@ -187,24 +191,163 @@ func startVCDJAnnouncer(vCDJ *Device, announceConn *net.UDPConn) error {
 type Config struct {
 	// NetIface allows you to configure the name of the interface used to
 	// communcate with the prolink network. usually does not need to be set.
+	// The loopback interface may be used to connect to a simulated network.
 	NetIface string
 
 	// VirtualCDJID is the device ID that should be used when broadcasting the
 	// virtual CDJ. Note that if the device ID is not 1-4 you cannot retrieve
 	// track details via USB.
+	//
+	// When left unset an unused device ID will be claimed automatically,
+	// preferring IDs 1-4, which takes several seconds. Use
+	// Network.VirtualCDJID to get the claimed ID. A configured device ID is
+	// only checked to not be in use, listening for the keep alive packets of
+	// devices already on the network, which is faster.
+	//
+	// Once connected, other devices attempting to claim the device ID of the
+	// virtual CDJ are told the device ID is in use.
 	VirtualCDJID DeviceID
 
-	// UseSniffing enables CDJ status to be reported even when another
-	// application has taken exclusive access to the UDP port status packets
-	// are reported on. Very useful when running rekordbox on the same machine.
+	// ReclaimDeviceID enables automatically claiming a new device ID for the
+	// virtual CDJ when another device begins using its device ID. Conflicts
+	// are always reported through DeviceManager.OnDeviceConflict.
+	ReclaimDeviceID bool
+
+	// StatusDispatch configures how CDJ status updates are delivered to
+	// status handlers. Enable ordered dispatch to guarantee each handler
+	// receives status updates in the order they were reported.
+	StatusDispatch DispatchConfig
+
+	// OnError is called when errors occur while communicating with the
+	// network in the background, such as failing to read or parse packets.
+	// Errors are reported as *NetworkError values. Errors from each source
+	// are reported in order, one at a time, while errors from different
+	// sources may be reported concurrently.
+	OnError func(*NetworkError)
+
+	// UseSniffing enables CDJ status and beats to be reported even when
+	// another application has taken exclusive access to the UDP ports status
+	// and beat packets are reported on. Very useful when running rekordbox on the same machine.
 	UseSniffing bool
+
+	// Transport provides the connections used to communicate with the
+	// network. When unset UDP sockets bound to NetIface are used. NetIface
+	// and UseSniffing have no effect when a Transport is provided.
+	Transport Transport
+
+	// Record enables recording every packet sent and received by the network
+	// to a file, including remote database connections.
+	Record RecordConfig
 }
 
 // Network is the priamry API to the PRO DJ LINK network.
 type Network struct {
-	cdjMonitor *CDJStatusMonitor
-	devManager *DeviceManager
-	remoteDB   *RemoteDB
+	cdjMonitor  *CDJStatusMonitor
+	beatMonitor *BeatMonitor
+	devManager  *DeviceManager
+	remoteDB    *RemoteDB
+	recorder    *packetRecorder
+
+	announceConn net.PacketConn
+	listenerConn PacketListener
+	beatConn     PacketListener
+	closeOnce    sync.Once
+	done         chan bool
+	onError      func(*NetworkError)
+
+	lock         sync.Mutex
+	vCDJ         *Device
+	stopAnnounce chan bool
+	reclaiming   bool
+}
+
+// VirtualCDJID reports the device ID of the virtual CDJ announced on the
+// network. The ID may change if Config.ReclaimDeviceID is enabled. No virtual
+// CDJ is announced when replaying a capture, in which case 0 is reported.
+func (n *Network) VirtualCDJID() DeviceID {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.vCDJ == nil {
+		return 0
+	}
+
+	return n.vCDJ.ID
+}
+
+// reclaimDeviceID claims a new device ID for the virtual CDJ after another
+// device has begun using its ID. The virtual CDJ is not announced while the
+// new device ID is being claimed.
+func (n *Network) reclaimDeviceID(conflict *DeviceConflict) error {
+	n.lock.Lock()
+
+	if n.reclaiming || conflict.Existing.ID != n.vCDJ.ID {
+		n.lock.Unlock()
+		return nil
+	}
+
+	select {
+	case <-n.done:
+		n.lock.Unlock()
+		return nil
+	default:
+	}
+
+	n.reclaiming = true
+	close(n.stopAnnounce)
+
+	vCDJ := *n.vCDJ
+	n.lock.Unlock()
+
+	claimer := &deviceIDClaimer{
+		vCDJ: &vCDJ,
+		conn: n.announceConn,
+		auto: true,
+		used: map[DeviceID]bool{conflict.ID: true},
+	}
+
+	for _, dev := range n.devManager.ActiveDevices() {
+		claimer.used[dev.ID] = true
+	}
+
+	// Packets are read by the device manager while reclaiming
+	claimer.wait = func(d time.Duration) error {
+		select {
+		case <-n.done:
+			return fmt.Errorf("Network closed while claiming device ID")
+		case <-time.After(d):
+			return nil
+		}
+	}
+
+	n.devManager.setClaimWatch(claimer.watchPacket)
+	err := claimer.claim()
+	n.devManager.setClaimWatch(nil)
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.reclaiming = false
+
+	select {
+	case <-n.done:
+		return nil
+	default:
+	}
+
+	// Continue announcing the previous device ID if we failed to claim a new
+	// device ID, the conflict will remain.
+	if err == nil {
+		n.vCDJ = &vCDJ
+		n.devManager.setVirtualCDJ(n.vCDJ)
+		n.remoteDB.setDeviceID(n.vCDJ.ID)
+	}
+
+	n.stopAnnounce = make(chan bool)
+	reportError := newErrorReporter(ErrorSourceAnnouncer, n.onError)
+	startVCDJAnnouncer(n.vCDJ, n.announceConn, n.stopAnnounce, reportError)
+
+	return err
 }
 
 // CDJStatusMonitor obtains the CDJStatusMonitor for the network.
@@ -212,6 +355,11 @@ func (n *Network) CDJStatusMonitor() *CDJStatusMonitor {
 	return n.cdjMonitor
 }
 
+// BeatMonitor obtains the BeatMonitor for the network.
+func (n *Network) BeatMonitor() *BeatMonitor {
+	return n.beatMonitor
+}
+
 // DeviceManager returns the DeviceManager for the network.
 func (n *Network) DeviceManager() *DeviceManager {
 	return n.devManager
@@ -222,52 +370,182 @@ func (n *Network) RemoteDB() *RemoteDB {
 	return n.remoteDB
 }
 
-// activeNetwork keeps
-var activeNetwork *Network
+// Close disconnects from the PRO DJ LINK network. The virtual CDJ will stop
+// being announced, all open connections will be closed, and no further device
+// or status changes will be reported.
+func (n *Network) Close() error {
+	var err error
+
+	n.closeOnce.Do(func() {
+		n.lock.Lock()
+		close(n.done)
+
+		if n.stopAnnounce != nil && !n.reclaiming {
+			close(n.stopAnnounce)
+		}
+		n.lock.Unlock()
+
+		n.cdjMonitor.deactivate()
+		n.beatMonitor.deactivate()
+		n.devManager.deactivate()
+		n.remoteDB.deactivate()
+
+		closers := []struct {
+			name  string
+			close func() error
+		}{
+			{"listener connection", n.listenerConn.Close},
+			{"beat connection", n.beatConn.Close},
+			{"announce connection", n.announceConn.Close},
+			{"packet recording", n.recorder.Close},
+		}
+
+		// Everything is closed even when closing fails, the first failure is
+		// reported.
+		for _, c := range closers {
+			if closeErr := c.close(); closeErr != nil && err == nil {
+				err = fmt.Errorf("Failed to close %s: %s", c.name, closeErr)
+			}
+		}
+	})
+
+	return err
+}
+
+// Run blocks until the context is done, at which point the network will be
+// closed. The context error is returned.
+func (n *Network) Run(ctx context.Context) error {
+	<-ctx.Done()
+	n.Close()
+
+	return ctx.Err()
+}
 
 // Connect connects to the Pioneer PRO DJ LINK network, returning a Network
-// object to interact with the connection.
+// object to interact with the connection. The network should be closed using
+// Network.Close when it is no longer needed.
+//
+// Each call to Connect returns an independent Network. Connecting multiple
+// times using different network interfaces allows for monitoring multiple
+// PRO DJ LINK networks at once. Sockets can only be bound to a network
+// interface on Linux, other platforms may only connect a single network at a
+// time unless a Transport is provided.
 func Connect(config Config) (*Network, error) {
-	if activeNetwork != nil {
-		return activeNetwork, nil
+	transport := config.Transport
+
+	if transport == nil {
+		udpTransport, err := newUDPTransport(config.NetIface, config.UseSniffing)
+		if err != nil {
+			return nil, err
+		}
+
+		transport = udpTransport
+	}
+
+	ip, mac, err := transport.LocalAddr()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to construct virtual CDJ: %s", err)
 	}
 
-	announceConn, err := net.ListenUDP("udp", announceAddr)
+	vCDJ := newVirtualCDJDevice(ip, mac, config.VirtualCDJID)
+
+	var recorder *packetRecorder
+
+	if config.Record.Path != "" {
+		recorder, err = newPacketRecorder(config.Record, mac,
+			newErrorReporter(ErrorSourceRecorder, config.OnError))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to start packet recording: %s", err)
+		}
+
+		transport = &recordingTransport{
+			Transport: transport,
+			recorder:  recorder,
+			localIP:   ip,
+		}
+	}
+
+	announceConn, err := transport.ListenAnnounce()
 	if err != nil {
+		recorder.Close()
 		return nil, fmt.Errorf("Cannot open UDP announce connection: %s", err)
 	}
 
-	netIface, err := getBroadcastInterface(config.NetIface)
+	err = claimDeviceID(vCDJ, announceConn, config.VirtualCDJID == 0)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to get broadcast interface: %s", err)
+		announceConn.Close()
+		recorder.Close()
+		return nil, fmt.Errorf("Failed to claim Virtual CDJ device ID: %s", err)
 	}
 
-	vCDJ, err := newVirtualCDJDevice(netIface, config.VirtualCDJID)
+	listenerConn, err := transport.ListenStatus()
 	if err != nil {
-		return nil, fmt.Errorf("Failed to construct virtual CDJ: %s", err)
+		announceConn.Close()
+		recorder.Close()
+		return nil, fmt.Errorf("Failed to open listener conection: %s", err)
 	}
 
-	err = startVCDJAnnouncer(vCDJ, announceConn)
+	beatConn, err := transport.ListenBeat()
 	if err != nil {
-		return nil, fmt.Errorf("Failed to start Virtual CDJ announcer: %s", err)
+		announceConn.Close()
+		listenerConn.Close()
+		recorder.Close()
+		return nil, fmt.Errorf("Failed to open beat listener conection: %s", err)
 	}
 
-	listenerConn, err := openListener(netIface, listenerAddr, config.UseSniffing)
+	stopAnnounce := make(chan bool)
+
+	reportError := newErrorReporter(ErrorSourceAnnouncer, config.OnError)
+
+	err = startVCDJAnnouncer(vCDJ, announceConn, stopAnnounce, reportError)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to open listener conection: %s", err)
+		announceConn.Close()
+		listenerConn.Close()
+		beatConn.Close()
+		recorder.Close()
+		return nil, fmt.Errorf("Failed to start Virtual CDJ announcer: %s", err)
 	}
 
 	network := &Network{
-		remoteDB:   newRemoteDB(),
-		cdjMonitor: newCDJStatusMonitor(),
-		devManager: newDeviceManager(),
+		remoteDB:    newRemoteDB(),
+		cdjMonitor:  newCDJStatusMonitor(config.StatusDispatch),
+		beatMonitor: newBeatMonitor(),
+		devManager:  newDeviceManager(),
+		recorder:    recorder,
+
+		vCDJ:         vCDJ,
+		announceConn: announceConn,
+		listenerConn: listenerConn,
+		beatConn:     beatConn,
+		stopAnnounce: stopAnnounce,
+		done:         make(chan bool),
+		onError:      config.OnError,
 	}
 
-	network.remoteDB.activate(network.devManager, vCDJ.ID)
-	network.cdjMonitor.activate(listenerConn)
-	network.devManager.activate(announceConn)
+	if config.ReclaimDeviceID {
+		reportError := newErrorReporter(ErrorSourceDeviceManager, config.OnError)
+
+		reclaim := func(c *DeviceConflict) {
+			if !c.IsVirtualCDJ {
+				return
+			}
+
+			if err := network.reclaimDeviceID(c); err != nil {
+				reportError(nil, nil, fmt.Errorf("Failed to reclaim device ID: %s", err))
+			}
+		}
+
+		network.devManager.OnDeviceConflict(DeviceConflictListenerFunc(reclaim))
+	}
 
-	activeNetwork = network
+	network.remoteDB.activate(network.devManager, vCDJ.ID, transport.Dial,
+		newErrorReporter(ErrorSourceRemoteDB, config.OnError))
+	network.cdjMonitor.activate(listenerConn,
+		newErrorReporter(ErrorSourceStatusMonitor, config.OnError))
+	network.beatMonitor.activate(beatConn,
+		newErrorReporter(ErrorSourceBeatMonitor, config.OnError))
+	network.devManager.activate(announceConn, vCDJ,
+		newErrorReporter(ErrorSourceDeviceManager, config.OnError))
 
 	return network, nil
 }
@@ -0,0 +1,115 @@
+package pdb
+
+import (
+	"sort"
+)
+
+// Database is a parsed export database. Rows of each table are keyed by
+// their ID.
+type Database struct {
+	Tracks    map[uint32]*Track
+	Artists   map[uint32]*Artist
+	Albums    map[uint32]*Album
+	Genres    map[uint32]*Genre
+	Labels    map[uint32]*Label
+	Keys      map[uint32]*Key
+	Colors    map[uint32]*Color
+	Artwork   map[uint32]*Artwork
+	Playlists map[uint32]*Playlist
+	History   map[uint32]*HistoryPlaylist
+}
+
+// Parse parses the contents of an export database.
+func Parse(data []byte) (*Database, error) {
+	r, err := newReader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	db := &Database{
+		Tracks:    map[uint32]*Track{},
+		Artists:   map[uint32]*Artist{},
+		Albums:    map[uint32]*Album{},
+		Genres:    map[uint32]*Genre{},
+		Labels:    map[uint32]*Label{},
+		Keys:      map[uint32]*Key{},
+		Colors:    map[uint32]*Color{},
+		Artwork:   map[uint32]*Artwork{},
+		Playlists: map[uint32]*Playlist{},
+		History:   map[uint32]*HistoryPlaylist{},
+	}
+
+	playlistEntries := []entry{}
+	historyEntries := []entry{}
+
+	tables := []struct {
+		kind  TableType
+		parse func(r *row)
+	}{
+		{TableTracks, func(r *row) { t := parseTrack(r); db.Tracks[t.ID] = t }},
+		{TableArtists, func(r *row) { a := parseArtist(r); db.Artists[a.ID] = a }},
+		{TableAlbums, func(r *row) { a := parseAlbum(r); db.Albums[a.ID] = a }},
+		{TableGenres, func(r *row) { g := parseGenre(r); db.Genres[g.ID] = g }},
+		{TableLabels, func(r *row) { l := parseLabel(r); db.Labels[l.ID] = l }},
+		{TableKeys, func(r *row) { k := parseKey(r); db.Keys[k.ID] = k }},
+		{TableColors, func(r *row) { c := parseColor(r); db.Colors[c.ID] = c }},
+		{TableArtwork, func(r *row) { a := parseArtwork(r); db.Artwork[a.ID] = a }},
+		{TablePlaylistTree, func(r *row) { p := parsePlaylist(r); db.Playlists[p.ID] = p }},
+		{TablePlaylistEntries, func(r *row) { playlistEntries = append(playlistEntries, parsePlaylistEntry(r)) }},
+		{TableHistoryPlaylists, func(r *row) { h := parseHistoryPlaylist(r); db.History[h.ID] = h }},
+		{TableHistoryEntries, func(r *row) { historyEntries = append(historyEntries, parseHistoryEntry(r)) }},
+	}
+
+	for _, t := range tables {
+		err := r.rows(t.kind, func(page []byte, offset int) error {
+			row := &row{page: page, offset: offset}
+			t.parse(row)
+
+			return row.err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sortEntries(playlistEntries)
+	for _, e := range playlistEntries {
+		if p, ok := db.Playlists[e.playlistID]; ok {
+			p.TrackIDs = append(p.TrackIDs, e.trackID)
+		}
+	}
+
+	sortEntries(historyEntries)
+	for _, e := range historyEntries {
+		if h, ok := db.History[e.playlistID]; ok {
+			h.TrackIDs = append(h.TrackIDs, e.trackID)
+		}
+	}
+
+	return db, nil
+}
+
+// sortEntries orders the entries by their index within their playlist.
+func sortEntries(entries []entry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].index < entries[j].index
+	})
+}
+
+// PlaylistChildren lists the playlists and playlist folders within the
+// folder, in their sort order. Top level playlists are listed using a folder
+// ID of zero.
+func (db *Database) PlaylistChildren(folderID uint32) []*Playlist {
+	children := []*Playlist{}
+	for _, p := range db.Playlists {
+		if p.ParentID == folderID {
+			children = append(children, p)
+		}
+	}
+
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].SortOrder < children[j].SortOrder
+	})
+
+	return children
+}
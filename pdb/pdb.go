@@ -0,0 +1,254 @@
+// Package pdb parses the export.pdb database rekordbox writes to USB and SD
+// media, found at PIONEER/rekordbox/export.pdb.
+//
+// The database is a DeviceSQL file made up of fixed size pages. The first page
+// lists each table of the database, and the pages of each table form a linked
+// list. Rows are stored within the heap of each page, and are located using an
+// index at the end of the page.
+package pdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"unicode/utf16"
+)
+
+// ErrMalformed is wrapped by errors returned when the database is not a valid
+// export database.
+var ErrMalformed = errors.New("Malformed export database")
+
+// TableType identifies the kind of rows stored in a table.
+type TableType uint32
+
+// Known table types.
+const (
+	TableTracks           TableType = 0x00
+	TableGenres           TableType = 0x01
+	TableArtists          TableType = 0x02
+	TableAlbums           TableType = 0x03
+	TableLabels           TableType = 0x04
+	TableKeys             TableType = 0x05
+	TableColors           TableType = 0x06
+	TablePlaylistTree     TableType = 0x07
+	TablePlaylistEntries  TableType = 0x08
+	TableHistoryPlaylists TableType = 0x0b
+	TableHistoryEntries   TableType = 0x0c
+	TableArtwork          TableType = 0x0d
+)
+
+// Layout of the file header and pages.
+const (
+	fileHeaderSize   = 0x1c
+	tableEntrySize   = 0x10
+	pageHeaderSize   = 0x28
+	rowGroupSize     = 0x24
+	rowsPerGroup     = 16
+	pageFlagsIndex   = 0x40
+	numRowsLargeNone = 0x1fff
+)
+
+// table locates the pages of a table.
+type table struct {
+	kind      TableType
+	firstPage uint32
+	lastPage  uint32
+}
+
+// reader reads the rows of the tables of a database.
+type reader struct {
+	data     []byte
+	pageSize uint32
+	tables   []table
+}
+
+// malformed constructs an error wrapping ErrMalformed.
+func malformed(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrMalformed, fmt.Sprintf(format, args...))
+}
+
+// newReader reads the file header of the database.
+func newReader(data []byte) (*reader, error) {
+	if len(data) < fileHeaderSize {
+		return nil, malformed("file is too short (%d bytes)", len(data))
+	}
+
+	r := &reader{
+		data:     data,
+		pageSize: binary.LittleEndian.Uint32(data[0x04:]),
+	}
+
+	if r.pageSize < pageHeaderSize+rowGroupSize {
+		return nil, malformed("invalid page size %d", r.pageSize)
+	}
+
+	numTables := binary.LittleEndian.Uint32(data[0x08:])
+	if uint64(fileHeaderSize)+uint64(numTables)*tableEntrySize > uint64(len(data)) {
+		return nil, malformed("header lists %d tables", numTables)
+	}
+
+	for i := uint32(0); i < numTables; i++ {
+		entry := data[fileHeaderSize+i*tableEntrySize:]
+
+		r.tables = append(r.tables, table{
+			kind:      TableType(binary.LittleEndian.Uint32(entry[0x00:])),
+			firstPage: binary.LittleEndian.Uint32(entry[0x08:]),
+			lastPage:  binary.LittleEndian.Uint32(entry[0x0c:]),
+		})
+	}
+
+	return r, nil
+}
+
+// page returns the data of the page at the index.
+func (r *reader) page(index uint32) ([]byte, error) {
+	start := uint64(index) * uint64(r.pageSize)
+	end := start + uint64(r.pageSize)
+
+	if end > uint64(len(r.data)) {
+		return nil, malformed("page %d is beyond the end of the file", index)
+	}
+
+	return r.data[start:end], nil
+}
+
+// rows calls fn with each row of the table, along with the page the row is
+// stored in and the offset of the row within the page. Tables which are not
+// present in the database have no rows.
+func (r *reader) rows(kind TableType, fn func(page []byte, offset int) error) error {
+	for _, t := range r.tables {
+		if t.kind != kind {
+			continue
+		}
+
+		// Guard against cycles in the list of pages
+		seen := map[uint32]bool{}
+
+		for index := t.firstPage; !seen[index]; {
+			seen[index] = true
+
+			page, err := r.page(index)
+			if err != nil {
+				return err
+			}
+
+			if err := r.pageRows(page, fn); err != nil {
+				return err
+			}
+
+			if index == t.lastPage {
+				break
+			}
+
+			index = binary.LittleEndian.Uint32(page[0x0c:])
+		}
+	}
+
+	return nil
+}
+
+// pageRows calls fn with each row present in the page.
+func (r *reader) pageRows(page []byte, fn func(page []byte, offset int) error) error {
+	// Index pages and pages of other tables have no rows
+	if page[0x1b]&pageFlagsIndex != 0 {
+		return nil
+	}
+
+	numRows := int(page[0x18])
+	if large := int(binary.LittleEndian.Uint16(page[0x22:])); large > numRows && large != numRowsLargeNone {
+		numRows = large
+	}
+
+	if numRows == 0 {
+		return nil
+	}
+
+	numGroups := (numRows-1)/rowsPerGroup + 1
+	if numGroups*rowGroupSize > len(page)-pageHeaderSize {
+		return malformed("page has too many rows (%d)", numRows)
+	}
+
+	for group := 0; group < numGroups; group++ {
+		base := len(page) - group*rowGroupSize
+		present := binary.LittleEndian.Uint16(page[base-4:])
+
+		for i := 0; i < rowsPerGroup && group*rowsPerGroup+i < numRows; i++ {
+			if present&(1<<uint(i)) == 0 {
+				continue
+			}
+
+			offset := pageHeaderSize + int(binary.LittleEndian.Uint16(page[base-6-i*2:]))
+			if offset >= len(page) {
+				return malformed("row offset %d is beyond the end of the page", offset)
+			}
+
+			if err := fn(page, offset); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Kinds of DeviceSQL strings, identified by the first byte of the string.
+const (
+	stringLongASCII = 0x40
+	stringLongUTF16 = 0x90
+)
+
+// readString decodes the DeviceSQL string at the offset of the page.
+func readString(page []byte, offset int) (string, error) {
+	if offset < 0 || offset >= len(page) {
+		return "", malformed("string offset %d is beyond the end of the page", offset)
+	}
+
+	kind := page[offset]
+
+	// Short ASCII strings include their length, including the kind byte, in
+	// the upper bits of the kind
+	if kind&0x01 == 1 {
+		end := offset + int(kind>>1)
+		if end > len(page) {
+			return "", malformed("string is beyond the end of the page")
+		}
+
+		return string(page[offset+1 : end]), nil
+	}
+
+	if offset+4 > len(page) {
+		return "", malformed("string is beyond the end of the page")
+	}
+
+	length := int(binary.LittleEndian.Uint16(page[offset+1:]))
+	if length < 4 || offset+length > len(page) {
+		return "", malformed("invalid string length %d", length)
+	}
+
+	text := page[offset+4 : offset+length]
+
+	switch kind {
+	case stringLongASCII:
+		return string(text), nil
+	case stringLongUTF16:
+		units := make([]uint16, len(text)/2)
+		for i := range units {
+			units[i] = binary.LittleEndian.Uint16(text[i*2:])
+		}
+
+		return string(utf16.Decode(units)), nil
+	}
+
+	return "", malformed("unknown string kind 0x%02x", kind)
+}
+
+// Open reads and parses the export database at the path.
+func Open(path string) (*Database, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(data)
+}
@@ -0,0 +1,223 @@
+package pdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testPageSize is the page size of database fixtures.
+const testPageSize = 0x200
+
+// shortString encodes a short ASCII DeviceSQL string.
+func shortString(s string) []byte {
+	return append([]byte{byte(len(s)+1)<<1 | 1}, s...)
+}
+
+// pageFixture constructs a data page holding the rows, linking to the next
+// page.
+func pageFixture(next uint32, rows ...[]byte) []byte {
+	page := make([]byte, testPageSize)
+	binary.LittleEndian.PutUint32(page[0x0c:], next)
+	page[0x18] = byte(len(rows))
+	page[0x1b] = 0x24
+
+	heap := 0
+	base := len(page)
+
+	for i, r := range rows {
+		copy(page[pageHeaderSize+heap:], r)
+		binary.LittleEndian.PutUint16(page[base-6-i*2:], uint16(heap))
+		heap += len(r)
+	}
+
+	binary.LittleEndian.PutUint16(page[base-4:], uint16(1<<uint(len(rows))-1))
+
+	return page
+}
+
+// databaseFixture constructs a database with a page for each table, in the
+// order of the tables.
+func databaseFixture(tables map[TableType][]byte) []byte {
+	kinds := []TableType{}
+	for kind := TableTracks; kind <= TableArtwork; kind++ {
+		if _, ok := tables[kind]; ok {
+			kinds = append(kinds, kind)
+		}
+	}
+
+	data := make([]byte, testPageSize)
+	binary.LittleEndian.PutUint32(data[0x04:], testPageSize)
+	binary.LittleEndian.PutUint32(data[0x08:], uint32(len(kinds)))
+
+	for i, kind := range kinds {
+		index := uint32(i + 1)
+
+		entry := data[fileHeaderSize+i*tableEntrySize:]
+		binary.LittleEndian.PutUint32(entry[0x00:], uint32(kind))
+		binary.LittleEndian.PutUint32(entry[0x08:], index)
+		binary.LittleEndian.PutUint32(entry[0x0c:], index)
+
+		data = append(data, tables[kind]...)
+	}
+
+	return data
+}
+
+// trackRow constructs a row of the tracks table.
+func trackRow(id, artistID uint32, title string) []byte {
+	row := make([]byte, 0x88)
+	binary.LittleEndian.PutUint32(row[0x08:], 44100)
+	binary.LittleEndian.PutUint32(row[0x30:], 320)
+	binary.LittleEndian.PutUint32(row[0x38:], 12800)
+	binary.LittleEndian.PutUint32(row[0x44:], artistID)
+	binary.LittleEndian.PutUint32(row[0x48:], id)
+	binary.LittleEndian.PutUint16(row[0x54:], 215)
+	row[0x59] = 4
+
+	// Every string is empty apart from the title and file path
+	empty := len(row)
+	row = append(row, shortString("")...)
+
+	for i := 0; i < 21; i++ {
+		binary.LittleEndian.PutUint16(row[0x5e+i*2:], uint16(empty))
+	}
+
+	binary.LittleEndian.PutUint16(row[0x5e+trackTitle*2:], uint16(len(row)))
+	row = append(row, shortString(title)...)
+
+	binary.LittleEndian.PutUint16(row[0x5e+trackFilePath*2:], uint16(len(row)))
+	row = append(row, shortString("/"+title+".mp3")...)
+
+	return row
+}
+
+func TestParse(t *testing.T) {
+	artist := append([]byte{0x60, 0x00, 0x00, 0x00, 0x07, 0x00, 0x00, 0x00, 0x00, 0x0a}, shortString("Artist")...)
+
+	// The genre name is a long UTF-16 string
+	genre := []byte{
+		0x02, 0x00, 0x00, 0x00,
+		0x90, 0x0e, 0x00, 0x00,
+		'H', 0x00, 'o', 0x00, 'u', 0x00, 's', 0x00, 'e', 0x00,
+	}
+
+	playlist := append([]byte{
+		0x00, 0x00, 0x00, 0x00, // parent
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, // sort order
+		0x03, 0x00, 0x00, 0x00, // ID
+		0x00, 0x00, 0x00, 0x00, // folder
+	}, shortString("Set")...)
+
+	data := databaseFixture(map[TableType][]byte{
+		TableTracks:       pageFixture(0, trackRow(1, 7, "One"), trackRow(2, 7, "Two")),
+		TableGenres:       pageFixture(0, genre),
+		TableArtists:      pageFixture(0, artist),
+		TablePlaylistTree: pageFixture(0, playlist),
+		TablePlaylistEntries: pageFixture(0,
+			[]byte{0x02, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00},
+			[]byte{0x01, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00},
+		),
+	})
+
+	db, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(db.Tracks) != 2 {
+		t.Fatalf("got %d tracks, want 2", len(db.Tracks))
+	}
+
+	track := db.Tracks[1]
+	want := &Track{
+		ID:         1,
+		Title:      "One",
+		FilePath:   "/One.mp3",
+		ArtistID:   7,
+		SampleRate: 44100,
+		BitRate:    320,
+		Tempo:      12800,
+		Duration:   215 * time.Second,
+		Rating:     4,
+	}
+
+	if !reflect.DeepEqual(track, want) {
+		t.Errorf("got track %+v, want %+v", track, want)
+	}
+
+	if a := db.Artists[7]; a == nil || a.Name != "Artist" {
+		t.Errorf("got artist %+v, want Artist", a)
+	}
+
+	if g := db.Genres[2]; g == nil || g.Name != "House" {
+		t.Errorf("got genre %+v, want House", g)
+	}
+
+	p := db.Playlists[3]
+	if p == nil || p.Name != "Set" || !reflect.DeepEqual(p.TrackIDs, []uint32{2, 1}) {
+		t.Errorf("got playlist %+v, want Set with tracks 2 and 1", p)
+	}
+
+	if children := db.PlaylistChildren(0); len(children) != 1 || children[0] != p {
+		t.Errorf("got top level playlists %+v", children)
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	valid := databaseFixture(map[TableType][]byte{
+		TableGenres: pageFixture(0, append([]byte{0x01, 0x00, 0x00, 0x00}, shortString("House")...)),
+	})
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"short header", valid[:fileHeaderSize-1]},
+		{"missing page", valid[:testPageSize]},
+		{"string beyond page", func() []byte {
+			data := append([]byte{}, valid...)
+			data[testPageSize+pageHeaderSize+4] = 0x40
+			binary.LittleEndian.PutUint16(data[testPageSize+pageHeaderSize+5:], 0xfff)
+			return data
+		}()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.data); !errors.Is(err, ErrMalformed) {
+				t.Errorf("got error %v, want ErrMalformed", err)
+			}
+		})
+	}
+}
+
+func TestReadString(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want string
+	}{
+		{[]byte{0x0d, 'H', 'o', 'u', 's', 'e'}, "House"},
+		{[]byte{0x03}, ""},
+		{[]byte{0x40, 0x07, 0x00, 0x00, 'D', 'u', 'b'}, "Dub"},
+		{[]byte{0x90, 0x08, 0x00, 0x00, 0xe9, 0x00, 'e', 0x00}, "ée"},
+	}
+
+	for _, tt := range tests {
+		got, err := readString(tt.data, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+
+	if _, err := readString([]byte{0x42, 0x04, 0x00, 0x00}, 0); !errors.Is(err, ErrMalformed) {
+		t.Errorf("got error %v for an unknown string kind, want ErrMalformed", err)
+	}
+}
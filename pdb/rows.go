@@ -0,0 +1,300 @@
+package pdb
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// row reads the fields of a single row. The first error encountered reading
+// the row is recorded, after which all fields read as zero.
+type row struct {
+	page   []byte
+	offset int
+	err    error
+}
+
+// field returns the n bytes at the offset of the row.
+func (r *row) field(at, n int) []byte {
+	start := r.offset + at
+	if r.err != nil || start+n > len(r.page) {
+		if r.err == nil {
+			r.err = malformed("row field at 0x%x is beyond the end of the page", at)
+		}
+
+		return make([]byte, n)
+	}
+
+	return r.page[start : start+n]
+}
+
+func (r *row) u8(at int) uint8 {
+	return r.field(at, 1)[0]
+}
+
+func (r *row) u16(at int) uint16 {
+	return binary.LittleEndian.Uint16(r.field(at, 2))
+}
+
+func (r *row) u32(at int) uint32 {
+	return binary.LittleEndian.Uint32(r.field(at, 4))
+}
+
+// str reads the string at the offset of the row.
+func (r *row) str(at int) string {
+	if r.err != nil {
+		return ""
+	}
+
+	s, err := readString(r.page, r.offset+at)
+	if err != nil {
+		r.err = err
+	}
+
+	return s
+}
+
+// Track is a row of the tracks table. Related rows, such as the artist of the
+// track, are referenced by their ID.
+type Track struct {
+	ID               uint32
+	Title            string
+	ArtistID         uint32
+	ComposerID       uint32
+	OriginalArtistID uint32
+	RemixerID        uint32
+	AlbumID          uint32
+	GenreID          uint32
+	LabelID          uint32
+	KeyID            uint32
+	ColorID          uint8
+	ArtworkID        uint32
+	TrackNumber      uint32
+	DiscNumber       uint16
+	Year             uint16
+	Rating           uint8
+	PlayCount        uint16
+	Comment          string
+	MixName          string
+	DateAdded        string
+	ReleaseDate      string
+	ISRC             string
+
+	// Tempo is the BPM of the track multiplied by 100.
+	Tempo uint32
+
+	// BitRate is given in kbps, and SampleRate in Hz. SampleDepth is the
+	// number of bits per sample.
+	BitRate     uint32
+	SampleRate  uint32
+	SampleDepth uint16
+
+	// Duration is given with a precision of seconds.
+	Duration time.Duration
+
+	// FileSize is the size of the audio file in bytes.
+	FileSize uint32
+
+	// FilePath is the path of the audio file on the media, and AnalyzePath
+	// is the path of its ANLZ0000.DAT analysis file.
+	FilePath    string
+	Filename    string
+	AnalyzePath string
+}
+
+// Indexes of the string offsets of track rows.
+const (
+	trackISRC        = 0
+	trackDateAdded   = 10
+	trackReleaseDate = 11
+	trackMixName     = 12
+	trackAnalyzePath = 14
+	trackComment     = 16
+	trackTitle       = 17
+	trackFilename    = 19
+	trackFilePath    = 20
+)
+
+// trackString reads the string of the track row at the index of its string
+// offsets.
+func trackString(r *row, index int) string {
+	return r.str(int(r.u16(0x5e + index*2)))
+}
+
+func parseTrack(r *row) *Track {
+	return &Track{
+		SampleRate:       r.u32(0x08),
+		ComposerID:       r.u32(0x0c),
+		FileSize:         r.u32(0x10),
+		ArtworkID:        r.u32(0x1c),
+		KeyID:            r.u32(0x20),
+		OriginalArtistID: r.u32(0x24),
+		LabelID:          r.u32(0x28),
+		RemixerID:        r.u32(0x2c),
+		BitRate:          r.u32(0x30),
+		TrackNumber:      r.u32(0x34),
+		Tempo:            r.u32(0x38),
+		GenreID:          r.u32(0x3c),
+		AlbumID:          r.u32(0x40),
+		ArtistID:         r.u32(0x44),
+		ID:               r.u32(0x48),
+		DiscNumber:       r.u16(0x4c),
+		PlayCount:        r.u16(0x4e),
+		Year:             r.u16(0x50),
+		SampleDepth:      r.u16(0x52),
+		Duration:         time.Duration(r.u16(0x54)) * time.Second,
+		ColorID:          r.u8(0x58),
+		Rating:           r.u8(0x59),
+		ISRC:             trackString(r, trackISRC),
+		DateAdded:        trackString(r, trackDateAdded),
+		ReleaseDate:      trackString(r, trackReleaseDate),
+		MixName:          trackString(r, trackMixName),
+		AnalyzePath:      trackString(r, trackAnalyzePath),
+		Comment:          trackString(r, trackComment),
+		Title:            trackString(r, trackTitle),
+		Filename:         trackString(r, trackFilename),
+		FilePath:         trackString(r, trackFilePath),
+	}
+}
+
+// Artist is a row of the artists table.
+type Artist struct {
+	ID   uint32
+	Name string
+}
+
+// Subtype of artist and album rows with a far offset to their name.
+const (
+	artistFarName = 0x64
+	albumFarName  = 0x84
+)
+
+func parseArtist(r *row) *Artist {
+	name := int(r.u8(0x09))
+	if r.u16(0x00) == artistFarName {
+		name = int(r.u16(0x0a))
+	}
+
+	return &Artist{ID: r.u32(0x04), Name: r.str(name)}
+}
+
+// Album is a row of the albums table.
+type Album struct {
+	ID       uint32
+	ArtistID uint32
+	Name     string
+}
+
+func parseAlbum(r *row) *Album {
+	name := int(r.u8(0x15))
+	if r.u16(0x00) == albumFarName {
+		name = int(r.u16(0x16))
+	}
+
+	return &Album{ID: r.u32(0x0c), ArtistID: r.u32(0x08), Name: r.str(name)}
+}
+
+// Genre is a row of the genres table.
+type Genre struct {
+	ID   uint32
+	Name string
+}
+
+func parseGenre(r *row) *Genre {
+	return &Genre{ID: r.u32(0x00), Name: r.str(0x04)}
+}
+
+// Label is a row of the labels table.
+type Label struct {
+	ID   uint32
+	Name string
+}
+
+func parseLabel(r *row) *Label {
+	return &Label{ID: r.u32(0x00), Name: r.str(0x04)}
+}
+
+// Key is a row of the musical keys table.
+type Key struct {
+	ID   uint32
+	Name string
+}
+
+func parseKey(r *row) *Key {
+	return &Key{ID: r.u32(0x00), Name: r.str(0x08)}
+}
+
+// Color is a row of the colors table, naming the color labels tracks may be
+// assigned.
+type Color struct {
+	ID   uint32
+	Name string
+}
+
+func parseColor(r *row) *Color {
+	return &Color{ID: uint32(r.u16(0x05)), Name: r.str(0x08)}
+}
+
+// Artwork is a row of the artwork table. Path is the path of the thumbnail
+// image on the media.
+type Artwork struct {
+	ID   uint32
+	Path string
+}
+
+func parseArtwork(r *row) *Artwork {
+	return &Artwork{ID: r.u32(0x00), Path: r.str(0x04)}
+}
+
+// Playlist is a playlist or playlist folder of the playlist tree. Top level
+// playlists have a ParentID of zero.
+type Playlist struct {
+	ID        uint32
+	ParentID  uint32
+	SortOrder uint32
+	Name      string
+	Folder    bool
+
+	// TrackIDs lists the tracks of the playlist in playlist order.
+	TrackIDs []uint32
+}
+
+func parsePlaylist(r *row) *Playlist {
+	return &Playlist{
+		ParentID:  r.u32(0x00),
+		SortOrder: r.u32(0x08),
+		ID:        r.u32(0x0c),
+		Folder:    r.u32(0x10) != 0,
+		Name:      r.str(0x14),
+		TrackIDs:  []uint32{},
+	}
+}
+
+// entry is a row of the playlist entries or history entries tables, placing a
+// track within a playlist.
+type entry struct {
+	index      uint32
+	trackID    uint32
+	playlistID uint32
+}
+
+func parsePlaylistEntry(r *row) entry {
+	return entry{index: r.u32(0x00), trackID: r.u32(0x04), playlistID: r.u32(0x08)}
+}
+
+func parseHistoryEntry(r *row) entry {
+	return entry{trackID: r.u32(0x00), playlistID: r.u32(0x04), index: r.u32(0x08)}
+}
+
+// HistoryPlaylist is a row of the history playlists table, recording the
+// tracks played in a session.
+type HistoryPlaylist struct {
+	ID   uint32
+	Name string
+
+	// TrackIDs lists the tracks played in the order they were played.
+	TrackIDs []uint32
+}
+
+func parseHistoryPlaylist(r *row) *HistoryPlaylist {
+	return &HistoryPlaylist{ID: r.u32(0x00), Name: r.str(0x04), TrackIDs: []uint32{}}
+}
@@ -0,0 +1,492 @@
+package prolink
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// RecordFormat selects the file format used to record packets.
+type RecordFormat int
+
+// Defined record formats.
+const (
+	// RecordPcapng records packets in the pcapng format, which may be opened
+	// using Wireshark or replayed using ConnectReplay. Ethernet, IP and UDP or
+	// TCP headers are reconstructed for each packet.
+	RecordPcapng RecordFormat = iota
+
+	// RecordJSONL records each packet as a line of JSON, including the time
+	// the packet was sent or received, the direction ("in" or "out"), the
+	// protocol ("udp" or "tcp"), the source and destination addresses, and
+	// the base64 encoded data.
+	RecordJSONL
+)
+
+// RecordConfig configures recording every packet sent and received by the
+// network to a file.
+type RecordConfig struct {
+	// Path is the file packets are recorded to. The file is truncated if it
+	// already exists. Recording is disabled when no path is given.
+	Path string
+
+	// Format is the file format of the recording.
+	Format RecordFormat
+}
+
+// recordedPacket is a single packet sent or received by the network.
+type recordedPacket struct {
+	time     time.Time
+	outgoing bool
+	tcp      bool
+	srcIP    net.IP
+	srcPort  int
+	dstIP    net.IP
+	dstPort  int
+	data     []byte
+
+	// seq and ack are the TCP sequence and acknowledgement numbers of the
+	// packet, tracked per connection.
+	seq uint32
+	ack uint32
+}
+
+// packetWriter writes recorded packets to a file.
+type packetWriter interface {
+	writePacket(p *recordedPacket) error
+}
+
+// jsonlRecord is the JSON representation of a recorded packet.
+type jsonlRecord struct {
+	Time        time.Time `json:"time"`
+	Direction   string    `json:"direction"`
+	Protocol    string    `json:"protocol"`
+	Source      string    `json:"source"`
+	Destination string    `json:"destination"`
+	Data        []byte    `json:"data"`
+}
+
+// jsonlWriter implements packetWriter, writing packets as lines of JSON.
+type jsonlWriter struct {
+	encoder *json.Encoder
+}
+
+func (w *jsonlWriter) writePacket(p *recordedPacket) error {
+	record := jsonlRecord{
+		Time:        p.time,
+		Direction:   "in",
+		Protocol:    "udp",
+		Source:      net.JoinHostPort(p.srcIP.String(), strconv.Itoa(p.srcPort)),
+		Destination: net.JoinHostPort(p.dstIP.String(), strconv.Itoa(p.dstPort)),
+		Data:        p.data,
+	}
+
+	if p.outgoing {
+		record.Direction = "out"
+	}
+
+	if p.tcp {
+		record.Protocol = "tcp"
+	}
+
+	return w.encoder.Encode(record)
+}
+
+// pcapngWriter implements packetWriter, writing packets as ethernet frames to
+// a pcapng file.
+type pcapngWriter struct {
+	writer   *pcapgo.NgWriter
+	localMAC net.HardwareAddr
+}
+
+func (w *pcapngWriter) writePacket(p *recordedPacket) error {
+	// The remote hardware address is not known
+	remoteMAC := net.HardwareAddr{0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+
+	eth := &layers.Ethernet{
+		SrcMAC:       remoteMAC,
+		DstMAC:       w.localMAC,
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+
+	if p.outgoing {
+		eth.SrcMAC, eth.DstMAC = w.localMAC, remoteMAC
+	}
+
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    p.srcIP.To4(),
+		DstIP:    p.dstIP.To4(),
+	}
+
+	var transport gopacket.SerializableLayer
+
+	if p.tcp {
+		ip.Protocol = layers.IPProtocolTCP
+
+		tcp := &layers.TCP{
+			SrcPort: layers.TCPPort(p.srcPort),
+			DstPort: layers.TCPPort(p.dstPort),
+			Seq:     p.seq,
+			Ack:     p.ack,
+			ACK:     true,
+			PSH:     true,
+			Window:  0xFFFF,
+		}
+		tcp.SetNetworkLayerForChecksum(ip)
+		transport = tcp
+	} else {
+		udp := &layers.UDP{
+			SrcPort: layers.UDPPort(p.srcPort),
+			DstPort: layers.UDPPort(p.dstPort),
+		}
+		udp.SetNetworkLayerForChecksum(ip)
+		transport = udp
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+
+	err := gopacket.SerializeLayers(buf, opts, eth, ip, transport, gopacket.Payload(p.data))
+	if err != nil {
+		return err
+	}
+
+	frame := buf.Bytes()
+
+	info := gopacket.CaptureInfo{
+		Timestamp:     p.time,
+		CaptureLength: len(frame),
+		Length:        len(frame),
+	}
+
+	if err := w.writer.WritePacket(info, frame); err != nil {
+		return err
+	}
+
+	// Flush every packet so the recording is complete should we crash
+	return w.writer.Flush()
+}
+
+// packetRecorder records packets to a file. Errors writing packets are given
+// to the errorReporter.
+type packetRecorder struct {
+	lock        sync.Mutex
+	file        *os.File
+	writer      packetWriter
+	closed      bool
+	reportError errorReporter
+}
+
+// record writes the packet to the recording.
+func (r *packetRecorder) record(p *recordedPacket) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.closed {
+		return
+	}
+
+	if err := r.writer.writePacket(p); err != nil {
+		r.reportError(nil, p.data, fmt.Errorf("Failed to record packet: %s", err))
+	}
+}
+
+// Close stops recording and closes the file. It is safe to call Close on a
+// nil packetRecorder.
+func (r *packetRecorder) Close() error {
+	if r == nil {
+		return nil
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.closed {
+		return nil
+	}
+
+	r.closed = true
+
+	return r.file.Close()
+}
+
+// newPacketRecorder creates the recording file. The hardware address is used
+// as the address of the local device in pcapng recordings.
+func newPacketRecorder(config RecordConfig, localMAC net.HardwareAddr, reportError errorReporter) (*packetRecorder, error) {
+	file, err := os.Create(config.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	recorder := &packetRecorder{
+		file:        file,
+		reportError: reportError,
+	}
+
+	switch config.Format {
+	case RecordJSONL:
+		recorder.writer = &jsonlWriter{encoder: json.NewEncoder(file)}
+	case RecordPcapng:
+		writer, err := pcapgo.NewNgWriter(file, layers.LinkTypeEthernet)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		recorder.writer = &pcapngWriter{writer: writer, localMAC: localMAC}
+	default:
+		file.Close()
+		return nil, fmt.Errorf("Unknown record format %d", config.Format)
+	}
+
+	return recorder, nil
+}
+
+// addrIPPort splits the IP address and port from a network address. Unknown
+// addresses are reported as the zero IPv4 address.
+func addrIPPort(addr net.Addr) (net.IP, int) {
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		if addr != nil {
+			return addr.IP, addr.Port
+		}
+	case *net.TCPAddr:
+		if addr != nil {
+			return addr.IP, addr.Port
+		}
+	case *net.IPAddr:
+		if addr != nil {
+			return addr.IP, 0
+		}
+	case nil:
+	default:
+		host, port, err := net.SplitHostPort(addr.String())
+		if ip := net.ParseIP(host); err == nil && ip != nil {
+			portNum, _ := strconv.Atoi(port)
+			return ip, portNum
+		}
+	}
+
+	return net.IPv4zero, 0
+}
+
+// recordingPacketConn records every packet read from and written to a UDP
+// connection.
+type recordingPacketConn struct {
+	net.PacketConn
+	recorder *packetRecorder
+	localIP  net.IP
+	port     int
+}
+
+// ReadFrom implements the net.PacketConn interface.
+func (c *recordingPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(p)
+	if err == nil {
+		srcIP, srcPort := addrIPPort(addr)
+
+		c.recorder.record(&recordedPacket{
+			time:    time.Now(),
+			srcIP:   srcIP,
+			srcPort: srcPort,
+			dstIP:   c.localIP,
+			dstPort: c.port,
+			data:    append([]byte(nil), p[:n]...),
+		})
+	}
+
+	return n, addr, err
+}
+
+// WriteTo implements the net.PacketConn interface.
+func (c *recordingPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	n, err := c.PacketConn.WriteTo(p, addr)
+	if err == nil {
+		dstIP, dstPort := addrIPPort(addr)
+
+		c.recorder.record(&recordedPacket{
+			time:     time.Now(),
+			outgoing: true,
+			srcIP:    c.localIP,
+			srcPort:  c.port,
+			dstIP:    dstIP,
+			dstPort:  dstPort,
+			data:     append([]byte(nil), p[:n]...),
+		})
+	}
+
+	return n, err
+}
+
+// recordingListener records every packet read from a PacketListener.
+type recordingListener struct {
+	PacketListener
+	recorder *packetRecorder
+	localIP  net.IP
+	port     int
+}
+
+// ReadFrom implements the PacketListener interface.
+func (l *recordingListener) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, addr, err := l.PacketListener.ReadFrom(p)
+	if err == nil {
+		srcIP, srcPort := addrIPPort(addr)
+
+		l.recorder.record(&recordedPacket{
+			time:    time.Now(),
+			srcIP:   srcIP,
+			srcPort: srcPort,
+			dstIP:   l.localIP,
+			dstPort: l.port,
+			data:    append([]byte(nil), p[:n]...),
+		})
+	}
+
+	return n, addr, err
+}
+
+// recordingConn records the data read from and written to a TCP stream.
+// Sequence numbers are tracked such that the stream may be reassembled.
+type recordingConn struct {
+	net.Conn
+	recorder   *packetRecorder
+	localIP    net.IP
+	localPort  int
+	remoteIP   net.IP
+	remotePort int
+
+	lock      sync.Mutex
+	localSeq  uint32
+	remoteSeq uint32
+}
+
+// Read implements the net.Conn interface.
+func (c *recordingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.lock.Lock()
+		packet := &recordedPacket{
+			time:    time.Now(),
+			tcp:     true,
+			srcIP:   c.remoteIP,
+			srcPort: c.remotePort,
+			dstIP:   c.localIP,
+			dstPort: c.localPort,
+			data:    append([]byte(nil), p[:n]...),
+			seq:     c.remoteSeq,
+			ack:     c.localSeq,
+		}
+		c.remoteSeq += uint32(n)
+		c.lock.Unlock()
+
+		c.recorder.record(packet)
+	}
+
+	return n, err
+}
+
+// Write implements the net.Conn interface.
+func (c *recordingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.lock.Lock()
+		packet := &recordedPacket{
+			time:     time.Now(),
+			outgoing: true,
+			tcp:      true,
+			srcIP:    c.localIP,
+			srcPort:  c.localPort,
+			dstIP:    c.remoteIP,
+			dstPort:  c.remotePort,
+			data:     append([]byte(nil), p[:n]...),
+			seq:      c.localSeq,
+			ack:      c.remoteSeq,
+		}
+		c.localSeq += uint32(n)
+		c.lock.Unlock()
+
+		c.recorder.record(packet)
+	}
+
+	return n, err
+}
+
+// recordingTransport wraps a Transport, recording every packet sent and
+// received using the connections it opens.
+type recordingTransport struct {
+	Transport
+	recorder *packetRecorder
+	localIP  net.IP
+}
+
+// ListenAnnounce implements the Transport interface.
+func (t *recordingTransport) ListenAnnounce() (net.PacketConn, error) {
+	conn, err := t.Transport.ListenAnnounce()
+	if err != nil {
+		return nil, err
+	}
+
+	return &recordingPacketConn{conn, t.recorder, t.localIP, announceAddr.Port}, nil
+}
+
+// ListenBeat implements the Transport interface.
+func (t *recordingTransport) ListenBeat() (PacketListener, error) {
+	listener, err := t.Transport.ListenBeat()
+	if err != nil {
+		return nil, err
+	}
+
+	return &recordingListener{listener, t.recorder, t.localIP, beatAddr.Port}, nil
+}
+
+// ListenStatus implements the Transport interface.
+func (t *recordingTransport) ListenStatus() (PacketListener, error) {
+	listener, err := t.Transport.ListenStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	return &recordingListener{listener, t.recorder, t.localIP, listenerAddr.Port}, nil
+}
+
+// Dial implements the Transport interface.
+func (t *recordingTransport) Dial(address string) (net.Conn, error) {
+	conn, err := t.Transport.Dial(address)
+	if err != nil {
+		return nil, err
+	}
+
+	remoteIP, remotePort := addrIPPort(conn.RemoteAddr())
+	// In memory connections do not have a meaningful remote address
+	if remoteIP.IsUnspecified() {
+		if remoteAddr, err := net.ResolveTCPAddr("tcp", address); err == nil {
+			remoteIP, remotePort = remoteAddr.IP, remoteAddr.Port
+		}
+	}
+
+	_, localPort := addrIPPort(conn.LocalAddr())
+
+	recorded := &recordingConn{
+		Conn:       conn,
+		recorder:   t.recorder,
+		localIP:    t.localIP,
+		localPort:  localPort,
+		remoteIP:   remoteIP,
+		remotePort: remotePort,
+		localSeq:   1,
+		remoteSeq:  1,
+	}
+
+	return recorded, nil
+}
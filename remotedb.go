@@ -3,63 +3,45 @@ package prolink
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net"
+	"strconv"
 	"sync"
 	"time"
-	"unicode/utf16"
+
+	"go.evanpurkhiser.com/prolink/dbserver"
 )
 
 // ErrDeviceNotLinked is returned by RemoteDB if the device being queried is
 // not currently 'linked' on the network.
 var ErrDeviceNotLinked = fmt.Errorf("The device is not linked on the network")
 
-// ErrCDUnsupported is returned when attempting to read metadata from a CD slot.
-// TODO: Figure out what packet sequence is needed to read CD metadata.
-var ErrCDUnsupported = fmt.Errorf("Reading metadata from CDs is currently unsupported")
-
-// rdSeparator is a 6 byte marker used in TCP packets sent sent and received
-// from the remote db server. It's not particular known exactly what this
-// value is for, but in some packets it seems to be used as a field separator.
-var rdSeparator = []byte{0x11, 0x87, 0x23, 0x49, 0xae, 0x11}
+// ErrCDUnsupported is returned when requesting data of a track in a CD slot
+// which is only available for tracks analyzed by rekordbox, such as beat grids
+// and waveforms.
+var ErrCDUnsupported = fmt.Errorf("The requested data is not available for CD tracks")
 
-// buildPacket constructs a packet to be sent to remote database.
-func buildPacket(messageID uint32, part []byte) []byte {
-	count := make([]byte, 4)
-	binary.BigEndian.PutUint32(count, messageID)
-
-	header := bytes.Join([][]byte{rdSeparator, count}, nil)
-
-	return append(header, part...)
-}
-
-// Given a byte array where the first 4 bytes contain the uint32 length of the
-// string (number of runes) followed by a UTF-16 representation of the string,
-// convert it to a string.
-func stringFromUTF16(s []byte) string {
-	size := binary.BigEndian.Uint32(s[:4])
-	s = s[4:][:size*2]
-
-	str16Bit := make([]uint16, 0, size)
-	for ; len(s) > 0; s = s[2:] {
-		str16Bit = append(str16Bit, binary.BigEndian.Uint16(s[:2]))
-	}
-
-	return string(utf16.Decode(str16Bit))[:size-1]
-}
+// ErrUnavailable is returned by RemoteDB when the remote database reports it
+// is unable to provide the requested data, such as a menu the device does not
+// support. ExportDB returns it when the analysis files of the track do not
+// include the requested data.
+var ErrUnavailable = fmt.Errorf("The requested data is not available")
 
 // rbDBServerQueryPort is the consistent port on which we can query the remote
 // db server for the port to connect to to communicate with it.
 const rbDBServerQueryPort = 12523
 
+// dialFunc opens a connection to the given host:port address.
+type dialFunc func(address string) (net.Conn, error)
+
 // getRemoteDBServerAddr queries the remote device for the port that the remote
 // database server is listening on for requests.
-func getRemoteDBServerAddr(deviceIP net.IP) (string, error) {
-	addr := fmt.Sprintf("%s:%d", deviceIP, rbDBServerQueryPort)
+func getRemoteDBServerAddr(dial dialFunc, deviceIP net.IP) (string, error) {
+	addr := net.JoinHostPort(deviceIP.String(), strconv.Itoa(rbDBServerQueryPort))
 
-	conn, err := net.Dial("tcp", addr)
+	conn, err := dial(addr)
 	if err != nil {
 		return "", err
 	}
@@ -90,92 +72,123 @@ func getRemoteDBServerAddr(deviceIP net.IP) (string, error) {
 
 	port := binary.BigEndian.Uint16(data)
 
-	return fmt.Sprintf("%s:%d", deviceIP, port), nil
+	return net.JoinHostPort(deviceIP.String(), strconv.Itoa(int(port))), nil
 }
 
 type deviceConnection struct {
 	remoteDB *RemoteDB
 	device   *Device
 	lock     *sync.Mutex
-	conn     net.Conn
 	msgCount uint32
 
+	// connLock guards the connection, which is opened in the background.
+	connLock sync.Mutex
+	conn     net.Conn
+	closed   bool
+
 	retryEvery time.Duration
 	disconnect chan bool
 }
 
+// connection returns the open connection to the device, or nil if we are not
+// yet connected.
+func (dc *deviceConnection) connection() net.Conn {
+	dc.connLock.Lock()
+	defer dc.connLock.Unlock()
+
+	return dc.conn
+}
+
 // connect attempts to open a TCP socket connection  to the device. This will
 // send the necessary packet sequence in order start communicating with the
 // database server once connected.
 func (dc *deviceConnection) connect() error {
-	addr, err := getRemoteDBServerAddr(dc.device.IP)
+	addr, err := getRemoteDBServerAddr(dc.remoteDB.dial, dc.device.IP)
 	if err != nil {
 		return err
 	}
 
-	conn, err := net.Dial("tcp", addr)
+	conn, err := dc.remoteDB.dial(addr)
 	if err != nil {
 		return err
 	}
 
-	// Begin connection to the remote database
-	if _, err = conn.Write([]byte{0x11, 0x00, 0x00, 0x00, 0x01}); err != nil {
+	// Begin connection to the remote database, the greeting is echoed back
+	if _, err = conn.Write(dbserver.Greeting.Bytes()); err != nil {
+		conn.Close()
 		return fmt.Errorf("Failed to connect to remote database: %s", err)
 	}
 
-	// No need to keep this response, but it *should* be 5 bytes
-	io.CopyN(ioutil.Discard, conn, 5)
-
-	// Send identification to the remote database
-	identifyParts := [][]byte{
-		rdSeparator,
+	if _, err = dbserver.ReadField(conn); err != nil {
+		conn.Close()
+		return fmt.Errorf("Failed to connect to remote database: %s", err)
+	}
 
-		// Possible mask to reset the message counter (?)
-		[]byte{0xff, 0xff, 0xff, 0xfe},
+	// Identify ourselves using the device ID we are assuming to communicate
+	// with the remote database
+	setup := &dbserver.Message{
+		TxID: dbserver.SetupTxID,
+		Type: dbserver.MsgSetup,
+		Args: []dbserver.Field{dbserver.Uint32(dc.remoteDB.getDeviceID())},
+	}
 
-		// Currently don't know what these bytes do, but they're needed to get
-		// the connection into a state where we can make queries
-		[]byte{
-			0x10, 0x00, 0x00, 0x0f, 0x01, 0x14, 0x00, 0x00,
-			0x00, 0x0c, 0x06, 0x00, 0x00, 0x00, 0x00, 0x00,
-			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x11, 0x00,
-			0x00, 0x00,
-		},
+	packet, err := setup.MarshalBinary()
+	if err != nil {
+		conn.Close()
+		return err
+	}
 
-		// The last byte of the identifier is the device ID that we are assuming
-		// to use to communicate with the remote database
-		[]byte{byte(dc.remoteDB.deviceID)},
+	if _, err = conn.Write(packet); err != nil {
+		conn.Close()
+		return fmt.Errorf("Failed to connect to remote database: %s", err)
 	}
 
-	if _, err = conn.Write(bytes.Join(identifyParts, nil)); err != nil {
+	if _, err = dbserver.ReadMessage(conn); err != nil {
+		conn.Close()
 		return fmt.Errorf("Failed to connect to remote database: %s", err)
 	}
 
-	// No need to keep this response, but it *should be 42 bytes
-	io.CopyN(ioutil.Discard, conn, 42)
+	dc.connLock.Lock()
+	defer dc.connLock.Unlock()
+
+	// The device connection may have been closed while connecting
+	if dc.closed {
+		conn.Close()
+		return nil
+	}
 
 	dc.conn = conn
 
 	return nil
 }
 
-func (dc *deviceConnection) tryConnect(ticker *time.Ticker) bool {
+// tryConnect attempts to connect to the device, reporting any errors that
+// occur while connecting.
+func (dc *deviceConnection) tryConnect() bool {
+	err := dc.connect()
+	if err != nil {
+		dc.remoteDB.reportError(&net.IPAddr{IP: dc.device.IP}, nil, err)
+	}
+
+	return err == nil
+}
+
+func (dc *deviceConnection) retryConnect(ticker *time.Ticker) bool {
 	select {
 	case <-dc.disconnect:
 		return true
 	case <-ticker.C:
-		return dc.connect() == nil
+		return dc.tryConnect()
 	}
 }
 
 func (dc *deviceConnection) ensureConnect() {
-	dc.disconnect = make(chan bool, 1)
 	ticker := time.NewTicker(dc.retryEvery)
 
 	// Attempt to immediately connect
-	dc.connect()
+	dc.tryConnect()
 
-	for dc.conn == nil && !dc.tryConnect(ticker) {
+	for dc.connection() == nil && !dc.retryConnect(ticker) {
 	}
 
 	ticker.Stop()
@@ -184,6 +197,7 @@ func (dc *deviceConnection) ensureConnect() {
 // Open begins attempting to connect to the device. If we're unable to connect
 // to the device we will retry until the deviceConnection is closed.
 func (dc *deviceConnection) Open() {
+	dc.disconnect = make(chan bool, 1)
 	go dc.ensureConnect()
 }
 
@@ -195,6 +209,11 @@ func (dc *deviceConnection) Close() {
 		close(dc.disconnect)
 	}
 
+	dc.connLock.Lock()
+	defer dc.connLock.Unlock()
+
+	dc.closed = true
+
 	if dc.conn != nil {
 		dc.conn.Close()
 		dc.conn = nil
@@ -203,17 +222,43 @@ func (dc *deviceConnection) Close() {
 
 // Track contains track information retrieved from the remote database.
 type Track struct {
-	ID      uint32
-	Path    string
-	Title   string
-	Artist  string
-	Album   string
-	Label   string
-	Genre   string
-	Comment string
-	Key     string
-	Length  time.Duration
-	Artwork []byte
+	ID             uint32
+	Path           string
+	Title          string
+	Artist         string
+	OriginalArtist string
+	Remixer        string
+	Album          string
+	Label          string
+	Genre          string
+	Comment        string
+	Key            string
+	BPM            float32
+	Rating         uint8
+	Color          TrackColor
+	Year           uint16
+	DateAdded      time.Time
+
+	// Length is given with a precision of seconds. Neither the remote
+	// database nor the export database store the milliseconds of the length.
+	Length time.Duration
+
+	// Artwork is the encoded album artwork of the track, use DecodeArtwork
+	// to decode it. HighResArtwork is set when the artwork is the high
+	// resolution variant requested using TrackQuery.HighResArtwork.
+	Artwork        []byte
+	HighResArtwork bool
+
+	// BitRate is given in kbps, and SampleRate in Hz.
+	BitRate    uint32
+	SampleRate uint32
+
+	// FileSize is the size of the audio file in bytes.
+	//
+	// The remote database does not report the SampleRate or FileSize of
+	// tracks, these are zero for tracks retrieved using RemoteDB.GetTrack and
+	// are only known for tracks read from an ExportDB.
+	FileSize uint64
 }
 
 // TrackQuery is used to make queries for track metadata.
@@ -222,6 +267,11 @@ type TrackQuery struct {
 	Slot     TrackSlot
 	DeviceID DeviceID
 
+	// HighResArtwork requests the high resolution variant of the artwork,
+	// falling back to the low resolution thumbnail when the device does not
+	// offer it.
+	HighResArtwork bool
+
 	// artworkID will be filled in after the track metadata is queried, this
 	// feild will be needed to lookup the track artwork.
 	artworkID uint32
@@ -229,15 +279,47 @@ type TrackQuery struct {
 
 // RemoteDB provides an interface to talking to the remote database.
 type RemoteDB struct {
+	dial        dialFunc
+	reportError errorReporter
+
+	lock     sync.Mutex
 	deviceID DeviceID
 	conns    map[DeviceID]*deviceConnection
+	closed   bool
+}
+
+// getConnection returns the deviceConnection for the device, or nil if the
+// device has no connection.
+func (rd *RemoteDB) getConnection(devID DeviceID) *deviceConnection {
+	rd.lock.Lock()
+	defer rd.lock.Unlock()
+
+	return rd.conns[devID]
+}
+
+// getDeviceID returns the device ID used to communicate with the remote
+// database.
+func (rd *RemoteDB) getDeviceID() DeviceID {
+	rd.lock.Lock()
+	defer rd.lock.Unlock()
+
+	return rd.deviceID
 }
 
 // IsLinked reports weather the DB server is available for the given device.
 func (rd *RemoteDB) IsLinked(devID DeviceID) bool {
-	devConn, ok := rd.conns[devID]
+	devConn := rd.getConnection(devID)
 
-	return ok && devConn.conn != nil
+	return devConn != nil && devConn.connection() != nil
+}
+
+// isConnectionError reports if the error indicates the connection to the
+// remote database is no longer usable, as the server closed the connection or
+// the response could not be understood.
+func isConnectionError(err error) bool {
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, dbserver.ErrMalformed)
 }
 
 // GetTrack queries the remote db for track details given a track ID.
@@ -246,50 +328,76 @@ func (rd *RemoteDB) GetTrack(q *TrackQuery) (*Track, error) {
 		return nil, ErrDeviceNotLinked
 	}
 
-	if q.Slot == TrackSlotCD {
-		return nil, ErrCDUnsupported
-	}
-
 	track, err := rd.executeQuery(q)
+	rd.refreshOnError(q.DeviceID, err)
 
-	// Refresh the connection if we EOF while querying the server
-	if err != nil && err == io.EOF {
-		rd.refreshConnection(rd.conns[q.DeviceID].device)
+	return track, err
+}
+
+// refreshOnError refreshes the connection to the device if the error returned
+// from a query indicates the connection is no longer usable.
+func (rd *RemoteDB) refreshOnError(devID DeviceID, err error) {
+	if err == nil || !isConnectionError(err) {
+		return
 	}
 
-	return track, err
+	if devConn := rd.getConnection(devID); devConn != nil {
+		rd.refreshConnection(devConn.device)
+	}
 }
 
 func (rd *RemoteDB) executeQuery(q *TrackQuery) (*Track, error) {
 	// Synchroize queries as not to distruct the query flow. We could probably
 	// be a little more precice about where the locks are, but for now the
 	// entire query is pretty fast, just lock the whole thing.
-	rd.conns[q.DeviceID].lock.Lock()
-	defer rd.conns[q.DeviceID].lock.Unlock()
+	devConn := rd.getConnection(q.DeviceID)
+	if devConn == nil {
+		return nil, ErrDeviceNotLinked
+	}
 
-	track, err := rd.queryTrackMetadata(q)
-	if err != nil {
-		return nil, err
+	devConn.lock.Lock()
+	defer devConn.lock.Unlock()
+
+	track := &Track{ID: q.TrackID}
+
+	// CD tracks have no path or artwork
+	if q.Slot == TrackSlotCD {
+		if err := rd.queryCDMetadata(q, track); err != nil {
+			return nil, err
+		}
+
+		return track, nil
 	}
 
-	path, err := rd.queryTrackPath(q)
-	if err != nil {
+	// Metadata is queried last, taking precedence over the track info
+	if err := rd.queryTrackInfo(q, track); err != nil {
 		return nil, err
 	}
 
-	track.Path = path
+	if err := rd.queryTrackMetadata(q, track); err != nil {
+		return nil, err
+	}
 
 	// No artwork, nothing left to do
-	if binary.BigEndian.Uint32(track.Artwork) == 0 {
-		// Empty the 4byte artwork ID so that it matches the empty value.
-		track.Artwork = nil
-
+	if q.artworkID == 0 {
 		return track, nil
 	}
 
-	q.artworkID = binary.BigEndian.Uint32(track.Artwork)
+	if q.HighResArtwork {
+		artwork, err := rd.queryArtwork(q, true)
+		if err != nil && err != ErrUnavailable {
+			return nil, err
+		}
+
+		if len(artwork) > 0 {
+			track.Artwork = artwork
+			track.HighResArtwork = true
+
+			return track, nil
+		}
+	}
 
-	artwork, err := rd.queryArtwork(q)
+	artwork, err := rd.queryArtwork(q, false)
 	if err != nil {
 		return nil, err
 	}
@@ -299,201 +407,316 @@ func (rd *RemoteDB) executeQuery(q *TrackQuery) (*Track, error) {
 	return track, nil
 }
 
+// dmst constructs the first argument of requests made for the track query.
+func (rd *RemoteDB) dmst(menu dbserver.Menu, q *TrackQuery) dbserver.Uint32 {
+	return dbserver.DMST(byte(rd.getDeviceID()), menu, byte(q.Slot), slotTrackType(q.Slot))
+}
+
+// slotTrackType is the type of tracks in the slot.
+func slotTrackType(slot TrackSlot) dbserver.TrackType {
+	if slot == TrackSlotCD {
+		return dbserver.TrackTypeCD
+	}
+
+	return dbserver.TrackTypeRekordbox
+}
+
 // queryTrackMetadata queries the rmote database for various metadata about a
-// track, returing a sparse Track object. The track Path and Artwork must be
-// looked up as separate queries.
+// track, populating the Track. The track Path and Artwork must be looked up as
+// separate queries.
 //
-// Note that the Artwork ID is populated in the Artwork field, as this value is
+// Note that the artwork ID is populated in the query, as this value is
 // returned with the track metadata and is needed to lookup the artwork.
-func (rd *RemoteDB) queryTrackMetadata(q *TrackQuery) (*Track, error) {
-	trackID := make([]byte, 4)
-	binary.BigEndian.PutUint32(trackID, q.TrackID)
+func (rd *RemoteDB) queryTrackMetadata(q *TrackQuery, track *Track) error {
+	items, err := rd.menuRequest(q.DeviceID, rd.dmst(dbserver.MenuMain, q),
+		dbserver.MsgMetadata, dbserver.Uint32(q.TrackID))
+	if err != nil {
+		return err
+	}
 
-	dvID := byte(rd.deviceID)
-	slot := byte(q.Slot)
+	for _, item := range items {
+		track.setMetadata(item)
 
-	part1 := []byte{
-		0x10, 0x20, 0x02, 0x0f, 0x02, 0x14, 0x00, 0x00,
-		0x00, 0x0c, 0x06, 0x06, 0x00, 0x00, 0x00, 0x00,
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x11, dvID,
-		0x01, slot, 0x01, 0x11,
+		if item.Type.Base() == dbserver.ItemTitle {
+			q.artworkID = item.ArtworkID
+		}
 	}
-	part1 = append(part1, trackID...)
 
-	part2 := []byte{
-		0x10, 0x30, 0x00, 0x0f, 0x06, 0x14, 0x00, 0x00,
-		0x00, 0x0c, 0x06, 0x06, 0x06, 0x06, 0x06, 0x06,
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x11, dvID,
-		0x01, slot, 0x01, 0x11, 0x00, 0x00, 0x00, 0x00,
-		0x11, 0x00, 0x00, 0x00, 0x0b, 0x11, 0x00, 0x00,
-		0x00, 0x00, 0x11, 0x00, 0x00, 0x00, 0x0b, 0x11,
-		0x00, 0x00, 0x00, 0x00,
+	return nil
+}
+
+// queryCDMetadata queries the remote database for the metadata of a track on
+// a CD. The title and artist are only known for CDs with CD-TEXT.
+func (rd *RemoteDB) queryCDMetadata(q *TrackQuery, track *Track) error {
+	items, err := rd.menuRequest(q.DeviceID, rd.dmst(dbserver.MenuMain, q),
+		dbserver.MsgUnanalyzedMeta, dbserver.Uint32(q.TrackID))
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		track.setMetadata(item)
 	}
 
-	items, err := rd.getMultimessageResp(q.DeviceID, part1, part2)
+	return nil
+}
+
+// queryTrackInfo looks up the file path of a track in rekordbox, along with
+// the other metadata listed in the track info menu.
+func (rd *RemoteDB) queryTrackInfo(q *TrackQuery, track *Track) error {
+	items, err := rd.menuRequest(q.DeviceID, rd.dmst(dbserver.MenuData, q),
+		dbserver.MsgTrackInfo, dbserver.Uint32(q.TrackID))
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		track.setMetadata(item)
+	}
+
+	if track.Path == "" {
+		return fmt.Errorf("Track info does not include the path of the track")
+	}
+
+	return nil
+}
+
+// queryArtwork requests artwork of a specific ID from the remote database.
+// The high resolution variant of the artwork is requested when highRes is set.
+func (rd *RemoteDB) queryArtwork(q *TrackQuery, highRes bool) ([]byte, error) {
+	args := []dbserver.Field{
+		rd.dmst(dbserver.MenuData, q),
+		dbserver.Uint32(q.artworkID),
+	}
+
+	if highRes {
+		args = append(args, dbserver.Uint32(1))
+	}
+
+	resp, err := rd.request(q.DeviceID, dbserver.MsgArtworkReply, &dbserver.Message{
+		Type: dbserver.MsgArtwork,
+		Args: args,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	length := binary.BigEndian.Uint32(items[3][28:32])
+	return resp.BlobArg(3)
+}
 
-	track := &Track{
-		ID:      q.TrackID,
-		Title:   stringFromUTF16(items[0][38:]),
-		Artist:  stringFromUTF16(items[1][38:]),
-		Album:   stringFromUTF16(items[2][38:]),
-		Comment: stringFromUTF16(items[5][38:]),
-		Key:     stringFromUTF16(items[6][38:]),
-		Genre:   stringFromUTF16(items[9][38:]),
-		Label:   stringFromUTF16(items[10][38:]),
-		Length:  time.Duration(length) * time.Second,
+// dataRequest requests binary data of a track, such as its beat grid, from the
+// remote database. The data blob of the response is returned.
+func (rd *RemoteDB) dataRequest(q *TrackQuery, kind, expect dbserver.MessageType, args ...dbserver.Field) ([]byte, error) {
+	if !rd.IsLinked(q.DeviceID) {
+		return nil, ErrDeviceNotLinked
+	}
 
-		// Artwork will be given in with the artwork ID
-		Artwork: items[0][len(items[0])-19:][:4],
+	if q.Slot == TrackSlotCD {
+		return nil, ErrCDUnsupported
 	}
 
-	return track, nil
+	data, err := rd.executeDataRequest(q, kind, expect, args...)
+	rd.refreshOnError(q.DeviceID, err)
+
+	return data, err
 }
 
-// queryTrackPath looks up the file path of a track in rekordbox.
-func (rd *RemoteDB) queryTrackPath(q *TrackQuery) (string, error) {
-	trackID := make([]byte, 4)
-	binary.BigEndian.PutUint32(trackID, q.TrackID)
+func (rd *RemoteDB) executeDataRequest(q *TrackQuery, kind, expect dbserver.MessageType, args ...dbserver.Field) ([]byte, error) {
+	devConn := rd.getConnection(q.DeviceID)
+	if devConn == nil {
+		return nil, ErrDeviceNotLinked
+	}
 
-	dvID := byte(rd.deviceID)
-	slot := byte(q.Slot)
+	devConn.lock.Lock()
+	defer devConn.lock.Unlock()
 
-	part1 := []byte{
-		0x10, 0x21, 0x02, 0x0f, 0x02, 0x14, 0x00, 0x00,
-		0x00, 0x0c, 0x06, 0x06, 0x00, 0x00, 0x00, 0x00,
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x11, dvID,
-		0x08, slot, 0x01, 0x11,
+	resp, err := rd.request(q.DeviceID, expect, &dbserver.Message{
+		Type: kind,
+		Args: append([]dbserver.Field{rd.dmst(dbserver.MenuData, q)}, args...),
+	})
+	if err != nil {
+		return nil, err
 	}
-	part1 = append(part1, trackID...)
 
-	part2 := []byte{
-		0x10, 0x30, 0x00, 0x0f, 0x06, 0x14, 0x00, 0x00,
-		0x00, 0x0c, 0x06, 0x06, 0x06, 0x06, 0x06, 0x06,
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x11, dvID,
-		0x08, slot, 0x01, 0x11, 0x00, 0x00, 0x00, 0x00,
-		0x11, 0x00, 0x00, 0x00, 0x06, 0x11, 0x00, 0x00,
-		0x00, 0x00, 0x11, 0x00, 0x00, 0x00, 0x06, 0x11,
-		0x00, 0x00, 0x00, 0x00,
+	return resp.BlobArg(3)
+}
+
+// openDeviceConnection returns the deviceConnection and open connection of
+// the device.
+func (rd *RemoteDB) openDeviceConnection(devID DeviceID) (*deviceConnection, net.Conn, error) {
+	devConn := rd.getConnection(devID)
+	if devConn == nil {
+		return nil, nil, ErrDeviceNotLinked
 	}
 
-	items, err := rd.getMultimessageResp(q.DeviceID, part1, part2)
-	if err != nil {
-		return "", err
+	conn := devConn.connection()
+	if conn == nil {
+		return nil, nil, ErrDeviceNotLinked
 	}
 
-	return stringFromUTF16(items[4][38:]), nil
+	return devConn, conn, nil
 }
 
-// getMultimessageResp is used for queries that that multiple packets to setup
-// and respond with mult-section bodies that can be split on the rbSection
-// delimiter.
-func (rd *RemoteDB) getMultimessageResp(devID DeviceID, p1, p2 []byte) ([][]byte, error) {
-	// Part one of query
-	packet := buildPacket(rd.conns[devID].msgCount, p1)
+// request sends a request to the device and reads the response, which is
+// expected to be of the given type.
+func (rd *RemoteDB) request(devID DeviceID, expect dbserver.MessageType, req *dbserver.Message) (*dbserver.Message, error) {
+	devConn, conn, err := rd.openDeviceConnection(devID)
+	if err != nil {
+		return nil, err
+	}
 
-	if err := rd.sendMessage(devID, packet); err != nil {
+	if err := rd.sendMessage(devConn, conn, req); err != nil {
 		return nil, err
 	}
 
-	messageID := rd.conns[devID].msgCount
+	resp, err := dbserver.ReadMessage(conn)
+	if err != nil {
+		return nil, err
+	}
 
-	// This data doesn't seem useful, there *should* be 42 bytes of it
-	io.CopyN(ioutil.Discard, rd.conns[devID].conn, 42)
+	if resp.Type == dbserver.MsgUnavailable {
+		return nil, ErrUnavailable
+	}
 
-	// Part two of query
-	packet = buildPacket(messageID, p2)
+	if resp.Type != expect {
+		return nil, fmt.Errorf("%w: expected response 0x%04x to request 0x%04x, got 0x%04x",
+			dbserver.ErrMalformed, expect, req.Type, resp.Type)
+	}
 
-	// As far as I can tell, these multi-section packets *do not* have a length
-	// marker for bytes in the message, or even how many sections they will
-	// have. So for now, look for the 'final section' which seems to always be
-	// empty. We can reuse buildPacket here even though this is not a packet.
-	finalSection := buildPacket(messageID, []byte{
-		0x10, 0x42, 0x01, 0x0f, 0x00, 0x14, 0x00, 0x00, 0x00,
-		0x0c, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-		0x00, 0x00, 0x00, 0x00,
-	})
+	if resp.TxID != req.TxID {
+		return nil, fmt.Errorf("%w: response transaction %d does not match request %d",
+			dbserver.ErrMalformed, resp.TxID, req.TxID)
+	}
 
-	if err := rd.sendMessage(devID, packet); err != nil {
+	return resp, nil
+}
+
+// menuRequest makes a request which prepares a menu on the device, and then
+// renders every item of the menu.
+func (rd *RemoteDB) menuRequest(devID DeviceID, dmst dbserver.Uint32, kind dbserver.MessageType, args ...dbserver.Field) ([]*dbserver.MenuItem, error) {
+	total, err := rd.prepareMenu(devID, dmst, kind, args...)
+	if err != nil {
 		return nil, err
 	}
 
-	part := make([]byte, 1024)
-	full := []byte{}
+	return rd.renderMenu(devID, dmst, 0, total, total)
+}
 
-	for !bytes.HasSuffix(full, finalSection) {
-		n, err := rd.conns[devID].conn.Read(part)
-		if err != nil {
-			return nil, err
-		}
+// prepareMenu makes a request which prepares a menu on the device, returning
+// the number of items available in the menu.
+func (rd *RemoteDB) prepareMenu(devID DeviceID, dmst dbserver.Uint32, kind dbserver.MessageType, args ...dbserver.Field) (uint32, error) {
+	req := &dbserver.Message{
+		Type: kind,
+		Args: append([]dbserver.Field{dmst}, args...),
+	}
 
-		full = append(full, part[:n]...)
+	resp, err := rd.request(devID, dbserver.MsgSuccess, req)
+	if err != nil {
+		return 0, err
 	}
 
-	// Break into sections (keep only interesting ones
-	sections := bytes.Split(full, rdSeparator)[2:]
-	sections = sections[:len(sections)-1]
+	total, err := resp.NumberArg(1)
+	if err != nil {
+		return 0, err
+	}
 
-	// Remove uint32 message counter from each section
-	for i := range sections {
-		sections[i] = sections[i][4:]
+	// No items are available in the menu
+	if total == 0xffffffff {
+		total = 0
 	}
 
-	return sections, nil
+	return total, nil
 }
 
-// queryArtwork requests artwork of a specific ID from the remote database.
-func (rd *RemoteDB) queryArtwork(q *TrackQuery) ([]byte, error) {
-	artID := make([]byte, 4)
-	binary.BigEndian.PutUint32(artID, q.artworkID)
+// menuBatchSize is the number of items rendered by a single render request.
+// Players do not render more items than this at once.
+const menuBatchSize = 64
 
-	dvID := byte(rd.deviceID)
-	slot := byte(q.Slot)
+// renderMenu renders count items of the prepared menu starting from the
+// offset. Items are rendered in batches of menuBatchSize.
+func (rd *RemoteDB) renderMenu(devID DeviceID, dmst dbserver.Uint32, offset, count, total uint32) ([]*dbserver.MenuItem, error) {
+	items := make([]*dbserver.MenuItem, 0, count)
 
-	part := []byte{
-		0x10, 0x20, 0x03, 0x0f, 0x02, 0x14, 0x00, 0x00,
-		0x00, 0x0c, 0x06, 0x06, 0x00, 0x00, 0x00, 0x00,
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x11, dvID,
-		0x08, slot, 0x01, 0x11,
+	for uint32(len(items)) < count {
+		batch := count - uint32(len(items))
+		if batch > menuBatchSize {
+			batch = menuBatchSize
+		}
+
+		rendered, err := rd.renderMenuBatch(devID, dmst, offset+uint32(len(items)), batch, total)
+		if err != nil {
+			return nil, err
+		}
+
+		// The menu has fewer items than expected
+		if len(rendered) == 0 {
+			break
+		}
+
+		items = append(items, rendered...)
 	}
-	part = append(part, artID...)
 
-	packet := buildPacket(rd.conns[q.DeviceID].msgCount, part)
+	return items, nil
+}
 
-	if err := rd.sendMessage(q.DeviceID, packet); err != nil {
-		return nil, err
+// renderMenuBatch makes a single render request, reading items until the
+// menu footer is received.
+func (rd *RemoteDB) renderMenuBatch(devID DeviceID, dmst dbserver.Uint32, offset, limit, total uint32) ([]*dbserver.MenuItem, error) {
+	render := &dbserver.Message{
+		Type: dbserver.MsgRenderMenu,
+		Args: []dbserver.Field{
+			dmst,
+			dbserver.Uint32(offset),
+			dbserver.Uint32(limit),
+			dbserver.Uint32(0),
+			dbserver.Uint32(total),
+			dbserver.Uint32(0),
+		},
 	}
 
-	// there is a uint32 at byte 48 containing the size of the image, simply
-	// read up until this value so we know how much more to read after.
-	data := make([]byte, 52)
+	if _, err := rd.request(devID, dbserver.MsgMenuHeader, render); err != nil {
+		return nil, err
+	}
 
-	_, err := rd.conns[q.DeviceID].conn.Read(data)
+	_, conn, err := rd.openDeviceConnection(devID)
 	if err != nil {
 		return nil, err
 	}
 
-	imgLen := binary.BigEndian.Uint32(data[48:52])
-	img := make([]byte, int(imgLen))
+	items := make([]*dbserver.MenuItem, 0, limit)
 
-	_, err = io.ReadFull(rd.conns[q.DeviceID].conn, img)
-	if err != nil {
-		return nil, err
+	for {
+		msg, err := dbserver.ReadMessage(conn)
+		if err != nil {
+			return nil, err
+		}
+
+		if msg.Type == dbserver.MsgMenuFooter {
+			break
+		}
+
+		item, err := dbserver.ParseMenuItem(msg)
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, item)
 	}
 
-	return img, nil
+	return items, nil
 }
 
-// sendMessage writes to the open connection and increments the message
-// counter.
-func (rd *RemoteDB) sendMessage(devID DeviceID, m []byte) error {
-	devConn := rd.conns[devID]
+// sendMessage writes the message to the open connection, using the message
+// counter as the transaction ID and then incrementing the counter.
+func (rd *RemoteDB) sendMessage(devConn *deviceConnection, conn net.Conn, m *dbserver.Message) error {
+	m.TxID = devConn.msgCount
 
-	if _, err := devConn.conn.Write(m); err != nil {
+	packet, err := m.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	if _, err := conn.Write(packet); err != nil {
 		return err
 	}
 
@@ -512,8 +735,15 @@ func (rd *RemoteDB) openConnection(dev *Device) {
 		retryEvery: 5 * time.Second,
 	}
 
-	conn.Open()
+	rd.lock.Lock()
+	defer rd.lock.Unlock()
+
+	if rd.closed {
+		return
+	}
+
 	rd.conns[dev.ID] = conn
+	conn.Open()
 }
 
 // refreshConnection attempts to reconnect to the specified device.
@@ -524,15 +754,25 @@ func (rd *RemoteDB) refreshConnection(dev *Device) {
 
 // closeConnection closes the active connection for the specified device.
 func (rd *RemoteDB) closeConnection(dev *Device) {
-	rd.conns[dev.ID].Close()
+	rd.lock.Lock()
+	conn, ok := rd.conns[dev.ID]
 	delete(rd.conns, dev.ID)
+	rd.lock.Unlock()
+
+	if ok {
+		conn.Close()
+	}
 }
 
 // activate begins actively listening for devices on the network hat support
 // remote database queries to be added to the PRO DJ LINK network. This
-// maintains adding and removing of device connections.
-func (rd *RemoteDB) activate(dm *DeviceManager, deviceID DeviceID) {
+// maintains adding and removing of device connections. Connections are opened
+// using the dial function, errors connecting to devices are given to the
+// errorReporter.
+func (rd *RemoteDB) activate(dm *DeviceManager, deviceID DeviceID, dial dialFunc, reportError errorReporter) {
 	rd.deviceID = deviceID
+	rd.dial = dial
+	rd.reportError = reportError
 
 	allowedDevices := map[DeviceType]bool{
 		DeviceTypeRB:  true,
@@ -554,8 +794,47 @@ func (rd *RemoteDB) activate(dm *DeviceManager, deviceID DeviceID) {
 	dm.OnDeviceRemoved(DeviceListenerFunc(onRemove))
 }
 
+// setDeviceID changes the device ID used to communicate with the remote
+// database. Open connections are refreshed to identify using the new ID.
+func (rd *RemoteDB) setDeviceID(deviceID DeviceID) {
+	rd.lock.Lock()
+	rd.deviceID = deviceID
+	rd.lock.Unlock()
+
+	for _, dev := range rd.connectedDevices() {
+		rd.refreshConnection(dev)
+	}
+}
+
+// connectedDevices lists the devices which have a deviceConnection.
+func (rd *RemoteDB) connectedDevices() []*Device {
+	rd.lock.Lock()
+	defer rd.lock.Unlock()
+
+	devices := make([]*Device, 0, len(rd.conns))
+	for _, conn := range rd.conns {
+		devices = append(devices, conn.device)
+	}
+
+	return devices
+}
+
+// deactivate closes all open device connections. No further connections will
+// be opened.
+func (rd *RemoteDB) deactivate() {
+	rd.lock.Lock()
+	rd.closed = true
+	rd.lock.Unlock()
+
+	for _, dev := range rd.connectedDevices() {
+		rd.closeConnection(dev)
+	}
+}
+
 func newRemoteDB() *RemoteDB {
 	return &RemoteDB{
-		conns: map[DeviceID]*deviceConnection{},
+		conns:       map[DeviceID]*deviceConnection{},
+		dial:        func(address string) (net.Conn, error) { return net.Dial("tcp", address) },
+		reportError: newErrorReporter(ErrorSourceRemoteDB, nil),
 	}
 }
@@ -0,0 +1,176 @@
+package prolink_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.evanpurkhiser.com/prolink"
+	"go.evanpurkhiser.com/prolink/remotedbtest"
+)
+
+// linkServer serves the remote database of a CDJ with device ID 2, and waits
+// for the RemoteDB to link with it.
+func linkServer(t *testing.T, fill func(s *remotedbtest.Server)) (*prolink.RemoteDB, *remotedbtest.Server) {
+	t.Helper()
+
+	network, transport := connectMemory(t, prolink.Config{})
+	dev := testDevice(2)
+
+	server := remotedbtest.NewServer(dev.ID)
+	fill(server)
+
+	if err := server.Start(transport.Listen, dev.IP); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { server.Close() })
+
+	announce(t, transport, dev)
+
+	rdb := network.RemoteDB()
+	waitLinked(t, rdb, dev.ID)
+
+	return rdb, server
+}
+
+// waitLinked waits for the RemoteDB to link with the device.
+func waitLinked(t *testing.T, rdb *prolink.RemoteDB, id prolink.DeviceID) {
+	t.Helper()
+
+	for start := time.Now(); !rdb.IsLinked(id); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > testTimeout {
+			t.Fatal("timed out waiting for the remote database to link")
+		}
+	}
+}
+
+func TestRemoteDBGetTrack(t *testing.T) {
+	track := &prolink.Track{
+		ID:        1,
+		Path:      "/Contents/Artist/Track.mp3",
+		Title:     "Track",
+		Artist:    "Artist",
+		Album:     "Album",
+		Label:     "Label",
+		Genre:     "House",
+		Comment:   "Comment",
+		Key:       "Am",
+		BPM:       124,
+		Rating:    4,
+		Color:     prolink.TrackColor(2),
+		Year:      2020,
+		BitRate:   320,
+		DateAdded: time.Date(2021, time.March, 4, 0, 0, 0, 0, time.UTC),
+		Length:    215 * time.Second,
+		Artwork:   []byte{0xff, 0xd8, 0xff, 0xd9},
+	}
+
+	rdb, _ := linkServer(t, func(s *remotedbtest.Server) {
+		s.AddTrack(track)
+	})
+
+	got, err := rdb.GetTrack(&prolink.TrackQuery{DeviceID: 2, Slot: prolink.TrackSlotUSB, TrackID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, track) {
+		t.Errorf("got %+v, want %+v", got, track)
+	}
+
+	_, err = rdb.GetTrack(&prolink.TrackQuery{DeviceID: 3, Slot: prolink.TrackSlotUSB, TrackID: 1})
+	if err != prolink.ErrDeviceNotLinked {
+		t.Errorf("got error %v for an unlinked device, want ErrDeviceNotLinked", err)
+	}
+}
+
+func TestRemoteDBGetBeatGrid(t *testing.T) {
+	grid := &prolink.BeatGrid{Beats: []prolink.GridBeat{
+		{Time: 100 * time.Millisecond, BeatInMeasure: 1, BPM: 120},
+		{Time: 600 * time.Millisecond, BeatInMeasure: 2, BPM: 120},
+	}}
+
+	rdb, _ := linkServer(t, func(s *remotedbtest.Server) {
+		s.AddTrack(&prolink.Track{ID: 1, Title: "Analyzed", Path: "/a.mp3"})
+		s.AddTrack(&prolink.Track{ID: 2, Title: "Unanalyzed", Path: "/b.mp3"})
+		s.AddBeatGrid(1, grid)
+	})
+
+	q := &prolink.TrackQuery{DeviceID: 2, Slot: prolink.TrackSlotUSB, TrackID: 1}
+
+	got, err := rdb.GetBeatGrid(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, grid) {
+		t.Errorf("got beat grid %+v, want %+v", got, grid)
+	}
+
+	q.TrackID = 2
+
+	if _, err := rdb.GetBeatGrid(q); err != prolink.ErrUnavailable {
+		t.Errorf("got error %v for an unanalyzed track, want ErrUnavailable", err)
+	}
+}
+
+func TestRemoteDBGetCueList(t *testing.T) {
+	cues := &prolink.CueList{Extended: true, Cues: []prolink.Cue{
+		{Position: time.Second},
+		{HotCue: 1, Position: 2 * time.Second, Comment: "Drop"},
+	}}
+
+	rdb, _ := linkServer(t, func(s *remotedbtest.Server) {
+		s.AddTrack(&prolink.Track{ID: 1, Title: "Track", Path: "/a.mp3"})
+		s.AddCueList(1, cues)
+	})
+
+	got, err := rdb.GetCueList(&prolink.TrackQuery{DeviceID: 2, Slot: prolink.TrackSlotUSB, TrackID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, cues) {
+		t.Errorf("got cue list %+v, want %+v", got, cues)
+	}
+}
+
+func TestRemoteDBFaults(t *testing.T) {
+	faults := []struct {
+		name  string
+		fault remotedbtest.Fault
+	}{
+		{"close", remotedbtest.FaultClose},
+		{"truncate", remotedbtest.FaultTruncate},
+		{"malformed", remotedbtest.FaultMalformed},
+	}
+
+	rdb, server := linkServer(t, func(s *remotedbtest.Server) {
+		s.AddTrack(&prolink.Track{ID: 1, Title: "Track", Path: "/a.mp3"})
+	})
+
+	q := &prolink.TrackQuery{DeviceID: 2, Slot: prolink.TrackSlotUSB, TrackID: 1}
+
+	for _, tt := range faults {
+		t.Run(tt.name, func(t *testing.T) {
+			server.InjectFault(tt.fault)
+
+			if _, err := rdb.GetTrack(q); err == nil {
+				t.Fatal("expected an error")
+			}
+
+			// The connection is reopened for the following query
+			waitLinked(t, rdb, q.DeviceID)
+
+			track, err := rdb.GetTrack(q)
+			if err != nil {
+				t.Fatalf("query after the fault failed: %s", err)
+			}
+
+			if track.Title != "Track" {
+				t.Errorf("got title %q, want Track", track.Title)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package remotedbtest
+
+import (
+	"encoding/binary"
+	"time"
+
+	"go.evanpurkhiser.com/prolink"
+	"go.evanpurkhiser.com/prolink/anlz"
+	"go.evanpurkhiser.com/prolink/dbserver"
+)
+
+// dataKey identifies binary data of a track, such as its beat grid, by the
+// type of the request for it. Data served from analysis files is further
+// identified by the tag of the analysis file.
+type dataKey struct {
+	kind    dbserver.MessageType
+	trackID uint32
+	tag     uint32
+}
+
+// dataReplies maps requests for binary track data to the type of their reply.
+var dataReplies = map[dbserver.MessageType]dbserver.MessageType{
+	dbserver.MsgBeatGrid:        dbserver.MsgBeatGridData,
+	dbserver.MsgWaveformPreview: dbserver.MsgWaveformPreviewData,
+	dbserver.MsgWaveformDetail:  dbserver.MsgWaveformDetailData,
+	dbserver.MsgAnlzTag:         dbserver.MsgAnlzTagData,
+	dbserver.MsgCueList:         dbserver.MsgCueListData,
+	dbserver.MsgCueListExt:      dbserver.MsgCueListExtData,
+}
+
+// requestDataKey is the dataKey of the data requested.
+func requestDataKey(req *dbserver.Message) dataKey {
+	arg := func(i int) uint32 {
+		v, _ := req.NumberArg(i)
+		return v
+	}
+
+	switch req.Type {
+	case dbserver.MsgWaveformPreview:
+		return dataKey{req.Type, arg(2), 0}
+	case dbserver.MsgAnlzTag:
+		return dataKey{req.Type, arg(1), arg(2)}
+	}
+
+	return dataKey{req.Type, arg(1), 0}
+}
+
+// addData stores binary data of a track.
+func (s *Server) addData(key dataKey, data []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.data[key] = data
+}
+
+// getData looks up binary data of a track, false is returned when the track
+// has no such data.
+func (s *Server) getData(key dataKey) ([]byte, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	data, ok := s.data[key]
+	return data, ok
+}
+
+// AddBeatGrid sets the beat grid served for the track. Beat grid requests for
+// tracks without a beat grid are answered as unavailable.
+func (s *Server) AddBeatGrid(trackID uint32, grid *prolink.BeatGrid) {
+	data, _ := grid.MarshalBinary()
+	s.addData(dataKey{dbserver.MsgBeatGrid, trackID, 0}, data)
+}
+
+// AddHighResArtwork sets the high resolution artwork served for the track,
+// which must have low resolution Artwork. Requests for high resolution artwork
+// of tracks without it are answered as unavailable.
+func (s *Server) AddHighResArtwork(trackID uint32, artwork []byte) {
+	s.addData(dataKey{dbserver.MsgArtwork, trackID, 1}, artwork)
+}
+
+// waveformTags maps the styles of detailed and preview waveforms served from
+// analysis files to their tag.
+var waveformTags = map[prolink.WaveformStyle][2]string{
+	prolink.WaveformColor:     {anlz.TagWaveformColorPreview, anlz.TagWaveformColorDetail},
+	prolink.WaveformThreeBand: {anlz.TagWaveform3BandPreview, anlz.TagWaveform3BandDetail},
+}
+
+// AddWaveform sets a waveform served for the track. A preview and detailed
+// waveform may be set for each style, requests for waveforms which have not
+// been set are answered as unavailable.
+func (s *Server) AddWaveform(trackID uint32, w *prolink.Waveform) error {
+	data, err := w.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	key := dataKey{dbserver.MsgWaveformPreview, trackID, 0}
+
+	switch {
+	case w.Style == prolink.WaveformMonochrome && w.Detail:
+		key.kind = dbserver.MsgWaveformDetail
+	case w.Style != prolink.WaveformMonochrome:
+		detail := 0
+		if w.Detail {
+			detail = 1
+		}
+
+		key.kind = dbserver.MsgAnlzTag
+		key.tag = uint32(dbserver.AnlzCode(waveformTags[w.Style][detail]))
+	}
+
+	s.addData(key, data)
+
+	return nil
+}
+
+// legacyCueListEntrySize is the number of bytes describing each cue of a cue
+// list served by players which do not support extended cue lists.
+const legacyCueListEntrySize = 0x24
+
+// AddCueList sets the cue list served for the track, as both an extended cue
+// list and a cue list without comments and colors.
+func (s *Server) AddCueList(trackID uint32, list *prolink.CueList) {
+	data, _ := list.MarshalBinary()
+
+	s.addData(dataKey{dbserver.MsgCueListExt, trackID, 0}, data)
+	s.AddLegacyCueList(trackID, list)
+}
+
+// AddLegacyCueList sets the cue list served for the track without serving an
+// extended cue list, as done by older players.
+func (s *Server) AddLegacyCueList(trackID uint32, list *prolink.CueList) {
+	data := make([]byte, len(list.Cues)*legacyCueListEntrySize)
+
+	// Positions are given in half frames, 150ths of a second
+	halfFrames := func(d time.Duration) uint32 {
+		return uint32(d * 150 / time.Second)
+	}
+
+	for i, c := range list.Cues {
+		entry := data[i*legacyCueListEntrySize:]
+
+		if c.Loop {
+			entry[0x00] = 1
+		}
+
+		entry[0x01] = 1
+		entry[0x02] = c.HotCue
+		binary.LittleEndian.PutUint32(entry[0x0c:], halfFrames(c.Position))
+		binary.LittleEndian.PutUint32(entry[0x10:], halfFrames(c.LoopEnd))
+	}
+
+	s.addData(dataKey{dbserver.MsgCueList, trackID, 0}, data)
+}
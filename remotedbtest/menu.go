@@ -0,0 +1,311 @@
+package remotedbtest
+
+import (
+	"sort"
+	"strings"
+
+	"go.evanpurkhiser.com/prolink"
+	"go.evanpurkhiser.com/prolink/dbserver"
+)
+
+// Playlist is a playlist, playlist folder or history playlist served by the
+// Server.
+type Playlist struct {
+	ID       uint32
+	ParentID uint32
+	Name     string
+	Folder   bool
+	TrackIDs []uint32
+}
+
+// AddPlaylist adds a playlist or playlist folder. Playlists at the top level
+// have a ParentID of zero.
+func (s *Server) AddPlaylist(p *Playlist) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.playlists = append(s.playlists, p)
+}
+
+// AddHistory adds a history playlist.
+func (s *Server) AddHistory(p *Playlist) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.history = append(s.history, p)
+}
+
+// rootMenu lists the menus of the root menu.
+var rootMenu = []*dbserver.MenuItem{
+	{ID: 0x02, Type: dbserver.ItemArtistMenu, Label: "ARTIST"},
+	{ID: 0x03, Type: dbserver.ItemAlbumMenu, Label: "ALBUM"},
+	{ID: 0x01, Type: dbserver.ItemGenreMenu, Label: "GENRE"},
+	{ID: 0x04, Type: dbserver.ItemTrackMenu, Label: "TRACK"},
+	{ID: 0x0c, Type: dbserver.ItemKeyMenu, Label: "KEY"},
+	{ID: 0x05, Type: dbserver.ItemPlaylistMenu, Label: "PLAYLIST"},
+	{ID: 0x16, Type: dbserver.ItemHistoryMenu, Label: "HISTORY"},
+}
+
+// catalogueIndex assigns IDs to the artists, albums, genres and keys of the
+// tracks, in sorted order starting from one.
+type catalogueIndex map[dbserver.ItemType]map[string]uint32
+
+// index builds the catalogueIndex of the servers tracks.
+func (s *Server) index() catalogueIndex {
+	values := map[dbserver.ItemType]map[string]bool{
+		dbserver.ItemArtist: {},
+		dbserver.ItemAlbum:  {},
+		dbserver.ItemGenre:  {},
+		dbserver.ItemKey:    {},
+	}
+
+	for _, t := range s.tracks {
+		values[dbserver.ItemArtist][t.Artist] = true
+		values[dbserver.ItemAlbum][t.Album] = true
+		values[dbserver.ItemGenre][t.Genre] = true
+		values[dbserver.ItemKey][t.Key] = true
+	}
+
+	index := catalogueIndex{}
+
+	for kind, names := range values {
+		sorted := make([]string, 0, len(names))
+		for name := range names {
+			sorted = append(sorted, name)
+		}
+
+		sort.Strings(sorted)
+
+		index[kind] = map[string]uint32{}
+		for i, name := range sorted {
+			index[kind][name] = uint32(i + 1)
+		}
+	}
+
+	return index
+}
+
+// items lists a menu item for each distinct value of the kind, for tracks
+// matching the filter.
+func (s *Server) items(index catalogueIndex, kind dbserver.ItemType, filter func(t trackIndex) bool) []*dbserver.MenuItem {
+	seen := map[uint32]bool{}
+	items := []*dbserver.MenuItem{}
+
+	for _, t := range s.sortedTracks(index) {
+		if !filter(t) {
+			continue
+		}
+
+		id := t.ids[kind]
+		if seen[id] {
+			continue
+		}
+
+		seen[id] = true
+		items = append(items, &dbserver.MenuItem{ID: id, Type: kind, Label: t.names[kind]})
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+
+	return items
+}
+
+// trackIndex is a track with the IDs of its artist, album, genre and key.
+type trackIndex struct {
+	id    uint32
+	title string
+	ids   map[dbserver.ItemType]uint32
+	names map[dbserver.ItemType]string
+}
+
+// trackItem is the menu item listing the track.
+func (t trackIndex) trackItem() *dbserver.MenuItem {
+	return &dbserver.MenuItem{
+		ID:     t.id,
+		Type:   dbserver.ItemArtist<<8 | dbserver.ItemTitle,
+		Label:  t.title,
+		Label2: t.names[dbserver.ItemArtist],
+	}
+}
+
+// sortedTracks lists the tracks ordered by ID.
+func (s *Server) sortedTracks(index catalogueIndex) []trackIndex {
+	tracks := make([]trackIndex, 0, len(s.tracks))
+
+	for _, t := range s.tracks {
+		names := map[dbserver.ItemType]string{
+			dbserver.ItemArtist: t.Artist,
+			dbserver.ItemAlbum:  t.Album,
+			dbserver.ItemGenre:  t.Genre,
+			dbserver.ItemKey:    t.Key,
+		}
+
+		ids := map[dbserver.ItemType]uint32{}
+		for kind, name := range names {
+			ids[kind] = index[kind][name]
+		}
+
+		tracks = append(tracks, trackIndex{id: t.ID, title: t.Title, ids: ids, names: names})
+	}
+
+	sort.Slice(tracks, func(i, j int) bool { return tracks[i].id < tracks[j].id })
+
+	return tracks
+}
+
+// trackItems lists the tracks matching the filter.
+func (s *Server) trackItems(index catalogueIndex, filter func(t trackIndex) bool) []*dbserver.MenuItem {
+	items := []*dbserver.MenuItem{}
+
+	for _, t := range s.sortedTracks(index) {
+		if filter(t) {
+			items = append(items, t.trackItem())
+		}
+	}
+
+	return items
+}
+
+// cdTrackItems lists the tracks of the CD, ordered by track number.
+func (s *Server) cdTrackItems() []*dbserver.MenuItem {
+	items := []*dbserver.MenuItem{}
+
+	for _, t := range s.cdTracks {
+		items = append(items, &dbserver.MenuItem{
+			ID:     t.ID,
+			Type:   dbserver.ItemArtist<<8 | dbserver.ItemTitle,
+			Label:  t.Title,
+			Label2: t.Artist,
+		})
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+
+	return items
+}
+
+// playlistTrackItems lists the tracks of the playlist, in playlist order.
+func (s *Server) playlistTrackItems(index catalogueIndex, p *Playlist) []*dbserver.MenuItem {
+	tracks := map[uint32]trackIndex{}
+	for _, t := range s.sortedTracks(index) {
+		tracks[t.id] = t
+	}
+
+	items := []*dbserver.MenuItem{}
+
+	for _, id := range p.TrackIDs {
+		if t, ok := tracks[id]; ok {
+			items = append(items, t.trackItem())
+		}
+	}
+
+	return items
+}
+
+// findPlaylist looks up a playlist by ID.
+func findPlaylist(playlists []*Playlist, id uint32) *Playlist {
+	for _, p := range playlists {
+		if p.ID == id {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// menu builds the items of the menu prepared by the request. False is
+// returned when the request is not a supported menu request.
+func (s *Server) menu(req *dbserver.Message) ([]*dbserver.MenuItem, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	arg := func(i int) uint32 {
+		v, _ := req.NumberArg(i)
+		return v
+	}
+
+	all := func(t trackIndex) bool { return true }
+
+	index := s.index()
+
+	switch req.Type {
+	case dbserver.MsgRootMenu:
+		return rootMenu, true
+	case dbserver.MsgTrackMenu:
+		if prolink.TrackSlot(arg(0)>>8) == prolink.TrackSlotCD {
+			return s.cdTrackItems(), true
+		}
+
+		return s.trackItems(index, all), true
+	case dbserver.MsgArtistMenu:
+		return s.items(index, dbserver.ItemArtist, all), true
+	case dbserver.MsgAlbumMenu:
+		return s.items(index, dbserver.ItemAlbum, all), true
+	case dbserver.MsgGenreMenu:
+		return s.items(index, dbserver.ItemGenre, all), true
+	case dbserver.MsgKeyMenu:
+		return s.items(index, dbserver.ItemKey, all), true
+	case dbserver.MsgArtistAlbumMenu:
+		return s.items(index, dbserver.ItemAlbum, func(t trackIndex) bool {
+			return t.ids[dbserver.ItemArtist] == arg(2)
+		}), true
+	case dbserver.MsgAlbumTrackMenu:
+		return s.trackItems(index, func(t trackIndex) bool {
+			return t.ids[dbserver.ItemAlbum] == arg(2)
+		}), true
+	case dbserver.MsgGenreArtistMenu:
+		return s.items(index, dbserver.ItemArtist, func(t trackIndex) bool {
+			return t.ids[dbserver.ItemGenre] == arg(2)
+		}), true
+	case dbserver.MsgPlaylist:
+		items := []*dbserver.MenuItem{}
+
+		// Tracks of a playlist are listed when the folder flag is unset
+		if arg(3) == 0 {
+			if p := findPlaylist(s.playlists, arg(2)); p != nil && !p.Folder {
+				items = s.playlistTrackItems(index, p)
+			}
+
+			return items, true
+		}
+
+		for _, p := range s.playlists {
+			if p.ParentID != arg(2) {
+				continue
+			}
+
+			kind := dbserver.ItemPlaylist
+			if p.Folder {
+				kind = dbserver.ItemFolder
+			}
+
+			items = append(items, &dbserver.MenuItem{ID: p.ID, Type: kind, Label: p.Name})
+		}
+
+		return items, true
+	case dbserver.MsgSearchMenu:
+		text, _ := req.StringArg(3)
+		text = strings.ToUpper(text)
+
+		return s.trackItems(index, func(t trackIndex) bool {
+			return strings.Contains(strings.ToUpper(t.title), text) ||
+				strings.Contains(strings.ToUpper(t.names[dbserver.ItemArtist]), text)
+		}), true
+	case dbserver.MsgHistoryMenu:
+		items := []*dbserver.MenuItem{}
+		for _, p := range s.history {
+			items = append(items, &dbserver.MenuItem{ID: p.ID, Type: dbserver.ItemHistory, Label: p.Name})
+		}
+
+		return items, true
+	case dbserver.MsgHistoryTrackMenu:
+		items := []*dbserver.MenuItem{}
+		if p := findPlaylist(s.history, arg(2)); p != nil {
+			items = s.playlistTrackItems(index, p)
+		}
+
+		return items, true
+	}
+
+	return nil, false
+}
@@ -0,0 +1,500 @@
+// Package remotedbtest provides an in-process remote database server, as found
+// on CDJs and rekordbox, for testing code which uses prolink.RemoteDB.
+//
+// The Server answers the remote database port lookup, the connection
+// handshake, requests for track metadata, paths, artwork, beat grids,
+// waveforms and cue lists, and browsing and searching menus for the tracks and
+// playlists it has been given. Faults may be injected to exercise error
+// handling.
+//
+//	transport := prolink.NewMemoryTransport()
+//
+//	server := remotedbtest.NewServer(0x02)
+//	server.AddTrack(&prolink.Track{ID: 1, Title: "Track"})
+//	server.Start(transport.Listen, net.IPv4(169, 254, 1, 2))
+//	defer server.Close()
+package remotedbtest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.evanpurkhiser.com/prolink"
+	"go.evanpurkhiser.com/prolink/dbserver"
+)
+
+// QueryPort is the port clients query for the port of the remote database
+// server.
+const QueryPort = 12523
+
+// Fault is an error the Server may be instructed to produce in place of
+// responding to a request.
+type Fault int
+
+// Faults which may be injected using InjectFault.
+const (
+	// FaultClose closes the connection without responding, the client will
+	// read io.EOF.
+	FaultClose Fault = iota + 1
+
+	// FaultTruncate responds with the first half of the response and then
+	// closes the connection.
+	FaultTruncate
+
+	// FaultMalformed responds with data the size of the response which is not
+	// a valid message, and then closes the connection.
+	FaultMalformed
+)
+
+// ListenFunc opens a listener for the host:port address, such as
+// MemoryTransport.Listen.
+type ListenFunc func(address string) (net.Listener, error)
+
+// Server is a fake remote database server.
+type Server struct {
+	deviceID prolink.DeviceID
+
+	lock        sync.Mutex
+	tracks      map[uint32]*prolink.Track
+	cdTracks    map[uint32]*prolink.Track
+	data        map[dataKey][]byte
+	playlists   []*Playlist
+	history     []*Playlist
+	faults      []Fault
+	listeners   []net.Listener
+	conns       map[net.Conn]bool
+	connections int
+}
+
+// AddTrack adds a track to the catalogue served by the server. Tracks with
+// Artwork are served with an artwork ID matching the track ID.
+func (s *Server) AddTrack(t *prolink.Track) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.tracks[t.ID] = t
+}
+
+// AddCDTrack adds a track to the CD served by the server in the CD slot. The
+// ID of the track is its track number.
+func (s *Server) AddCDTrack(t *prolink.Track) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.cdTracks[t.ID] = t
+}
+
+// InjectFault queues a fault to be produced in place of the response to the
+// next request, including the handshake request. Multiple faults apply to the
+// following requests in the order they were injected.
+func (s *Server) InjectFault(f Fault) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.faults = append(s.faults, f)
+}
+
+// Connections reports the number of remote database connections that have
+// been accepted, which may be used to observe clients reconnecting.
+func (s *Server) Connections() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.connections
+}
+
+// Disconnect closes all open remote database connections, as if the device
+// had dropped them. The server continues accepting new connections.
+func (s *Server) Disconnect() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for conn := range s.conns {
+		conn.Close()
+		delete(s.conns, conn)
+	}
+}
+
+// Start begins serving on the IP address, answering port lookups on the
+// QueryPort and remote database connections on a port chosen by the listen
+// function. When listen is nil TCP sockets are used.
+func (s *Server) Start(listen ListenFunc, ip net.IP) error {
+	if listen == nil {
+		listen = func(address string) (net.Listener, error) {
+			return net.Listen("tcp", address)
+		}
+	}
+
+	dbListener, err := listen(net.JoinHostPort(ip.String(), "0"))
+	if err != nil {
+		return fmt.Errorf("Failed to listen for remote database connections: %s", err)
+	}
+
+	queryListener, err := listen(net.JoinHostPort(ip.String(), strconv.Itoa(QueryPort)))
+	if err != nil {
+		dbListener.Close()
+		return fmt.Errorf("Failed to listen for port queries: %s", err)
+	}
+
+	addr, ok := dbListener.Addr().(*net.TCPAddr)
+	if !ok {
+		dbListener.Close()
+		queryListener.Close()
+		return fmt.Errorf("Listener address %s is not a TCP address", dbListener.Addr())
+	}
+
+	s.lock.Lock()
+	s.listeners = append(s.listeners, dbListener, queryListener)
+	s.lock.Unlock()
+
+	go s.Serve(dbListener)
+	go ServeQueryPort(queryListener, uint16(addr.Port))
+
+	return nil
+}
+
+// Close stops the server, closing its listeners and open connections.
+func (s *Server) Close() error {
+	s.lock.Lock()
+	listeners := s.listeners
+	s.listeners = nil
+	s.lock.Unlock()
+
+	for _, l := range listeners {
+		l.Close()
+	}
+
+	s.Disconnect()
+
+	return nil
+}
+
+// Serve accepts remote database connections from the listener until it is
+// closed.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		s.lock.Lock()
+		s.conns[conn] = true
+		s.connections++
+		s.lock.Unlock()
+
+		go s.serveConn(conn)
+	}
+}
+
+// ServeQueryPort answers requests for the port of the remote database server
+// from the listener until it is closed.
+func ServeQueryPort(l net.Listener, port uint16) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			defer conn.Close()
+
+			// The request is a uint32 length followed by "RemoteDBServer\0"
+			request := make([]byte, 19)
+			if _, err := io.ReadFull(conn, request); err != nil {
+				return
+			}
+
+			reply := make([]byte, 2)
+			binary.BigEndian.PutUint16(reply, port)
+			conn.Write(reply)
+		}()
+	}
+}
+
+// nextFault removes the next injected fault, returning zero if there is none.
+func (s *Server) nextFault() Fault {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.faults) == 0 {
+		return 0
+	}
+
+	f := s.faults[0]
+	s.faults = s.faults[1:]
+
+	return f
+}
+
+// getTrack looks up a track in the catalogue.
+func (s *Server) getTrack(id uint32) *prolink.Track {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.tracks[id]
+}
+
+// getCDTrack looks up a track of the CD.
+func (s *Server) getCDTrack(id uint32) *prolink.Track {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.cdTracks[id]
+}
+
+// metadataItems lists the menu items describing a tracks metadata.
+func metadataItems(t *prolink.Track) []*dbserver.MenuItem {
+	return []*dbserver.MenuItem{
+		{Type: dbserver.ItemTitle, ID: t.ID, Label: t.Title, ArtworkID: artworkID(t)},
+		{Type: dbserver.ItemArtist, Label: t.Artist},
+		{Type: dbserver.ItemAlbum, Label: t.Album},
+		{Type: dbserver.ItemDuration, ID: uint32(t.Length / time.Second)},
+		{Type: dbserver.ItemTempo, ID: uint32(t.BPM*100 + 0.5)},
+		{Type: dbserver.ItemComment, Label: t.Comment},
+		{Type: dbserver.ItemKey, Label: t.Key},
+		{Type: dbserver.ItemRating, ID: uint32(t.Rating)},
+		colorItem(t),
+		{Type: dbserver.ItemGenre, Label: t.Genre},
+		{Type: dbserver.ItemLabel, Label: t.Label},
+		{Type: dbserver.ItemOriginalArtist, Label: t.OriginalArtist},
+		{Type: dbserver.ItemRemixer, Label: t.Remixer},
+		{Type: dbserver.ItemYear, ID: uint32(t.Year)},
+		{Type: dbserver.ItemDateAdded, Label: dateAdded(t)},
+	}
+}
+
+// cdMetadataItems lists the menu items describing the metadata of a CD track.
+func cdMetadataItems(t *prolink.Track) []*dbserver.MenuItem {
+	return []*dbserver.MenuItem{
+		{Type: dbserver.ItemTitle, ID: t.ID, Label: t.Title},
+		{Type: dbserver.ItemArtist, Label: t.Artist},
+		{Type: dbserver.ItemDuration, ID: uint32(t.Length / time.Second)},
+	}
+}
+
+// trackInfoItems lists the menu items of the track info menu, which includes
+// the path of the track.
+func trackInfoItems(t *prolink.Track) []*dbserver.MenuItem {
+	return []*dbserver.MenuItem{
+		{Type: dbserver.ItemTitle, ID: t.ID, Label: t.Title, ArtworkID: artworkID(t)},
+		{Type: dbserver.ItemArtist, Label: t.Artist},
+		{Type: dbserver.ItemAlbum, Label: t.Album},
+		{Type: dbserver.ItemDuration, ID: uint32(t.Length / time.Second)},
+		{Type: dbserver.ItemBitRate, ID: t.BitRate},
+		{Type: dbserver.ItemPath, Label: t.Path},
+	}
+}
+
+// colorItem is the menu item describing the color of the track.
+func colorItem(t *prolink.Track) *dbserver.MenuItem {
+	item := &dbserver.MenuItem{Type: dbserver.ItemColor + dbserver.ItemType(t.Color)}
+
+	if t.Color != prolink.TrackColorNone {
+		item.Label = t.Color.String()
+	}
+
+	return item
+}
+
+// dateAdded is the label of the date added item of the track.
+func dateAdded(t *prolink.Track) string {
+	if t.DateAdded.IsZero() {
+		return ""
+	}
+
+	return t.DateAdded.Format("2006-01-02")
+}
+
+// artworkID is the ID artwork of the track is served under, zero when the
+// track has no artwork.
+func artworkID(t *prolink.Track) uint32 {
+	if len(t.Artwork) == 0 {
+		return 0
+	}
+
+	return t.ID
+}
+
+// respond constructs the response messages to a request. Menu items to be
+// rendered by the following render request are tracked using pending.
+func (s *Server) respond(req *dbserver.Message, pending *[]*dbserver.MenuItem) []*dbserver.Message {
+	reply := func(kind dbserver.MessageType, args ...dbserver.Field) *dbserver.Message {
+		return &dbserver.Message{TxID: req.TxID, Type: kind, Args: args}
+	}
+
+	trackArg := func() *prolink.Track {
+		id, err := req.NumberArg(1)
+		if err != nil {
+			return nil
+		}
+
+		return s.getTrack(id)
+	}
+
+	switch req.Type {
+	case dbserver.MsgSetup:
+		return []*dbserver.Message{
+			reply(dbserver.MsgSuccess, dbserver.Uint32(0), dbserver.Uint32(s.deviceID)),
+		}
+	case dbserver.MsgMetadata, dbserver.MsgTrackInfo:
+		*pending = nil
+
+		if t := trackArg(); t != nil && req.Type == dbserver.MsgMetadata {
+			*pending = metadataItems(t)
+		} else if t != nil {
+			*pending = trackInfoItems(t)
+		}
+
+		return []*dbserver.Message{
+			reply(dbserver.MsgSuccess, dbserver.Uint32(req.Type), dbserver.Uint32(len(*pending))),
+		}
+	case dbserver.MsgUnanalyzedMeta:
+		id, _ := req.NumberArg(1)
+
+		t := s.getCDTrack(id)
+		if t == nil {
+			break
+		}
+
+		*pending = cdMetadataItems(t)
+
+		return []*dbserver.Message{
+			reply(dbserver.MsgSuccess, dbserver.Uint32(req.Type), dbserver.Uint32(len(*pending))),
+		}
+	case dbserver.MsgRenderMenu:
+		offset, _ := req.NumberArg(1)
+		limit, _ := req.NumberArg(2)
+
+		msgs := []*dbserver.Message{reply(dbserver.MsgMenuHeader, dbserver.Uint32(0))}
+
+		for i := offset; i < offset+limit && i < uint32(len(*pending)); i++ {
+			msgs = append(msgs, (*pending)[i].Message(req.TxID))
+		}
+
+		return append(msgs, reply(dbserver.MsgMenuFooter))
+	case dbserver.MsgArtwork:
+		var artwork []byte
+		if t := trackArg(); t != nil {
+			artwork = t.Artwork
+		}
+
+		// High resolution artwork is requested using a third argument
+		if highRes, err := req.NumberArg(2); err == nil && highRes == 1 {
+			id, _ := req.NumberArg(1)
+
+			data, ok := s.getData(dataKey{dbserver.MsgArtwork, id, 1})
+			if !ok {
+				break
+			}
+
+			artwork = data
+		}
+
+		return []*dbserver.Message{reply(dbserver.MsgArtworkReply,
+			dbserver.Uint32(req.Type),
+			dbserver.Uint32(0),
+			dbserver.Uint32(len(artwork)),
+			dbserver.Blob(artwork),
+		)}
+	}
+
+	if kind, ok := dataReplies[req.Type]; ok {
+		if data, ok := s.getData(requestDataKey(req)); ok {
+			return []*dbserver.Message{reply(kind,
+				dbserver.Uint32(req.Type),
+				dbserver.Uint32(0),
+				dbserver.Uint32(len(data)),
+				dbserver.Blob(data),
+			)}
+		}
+	}
+
+	if items, ok := s.menu(req); ok {
+		*pending = items
+
+		return []*dbserver.Message{
+			reply(dbserver.MsgSuccess, dbserver.Uint32(req.Type), dbserver.Uint32(len(items))),
+		}
+	}
+
+	return []*dbserver.Message{
+		reply(dbserver.MsgUnavailable, dbserver.Uint32(req.Type), dbserver.Uint32(0)),
+	}
+}
+
+// encodeMessages encodes the messages as a single response.
+func encodeMessages(msgs []*dbserver.Message) []byte {
+	buf := &bytes.Buffer{}
+
+	for _, m := range msgs {
+		data, _ := m.MarshalBinary()
+		buf.Write(data)
+	}
+
+	return buf.Bytes()
+}
+
+// serveConn answers requests on a single remote database connection.
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.lock.Lock()
+		delete(s.conns, conn)
+		s.lock.Unlock()
+
+		conn.Close()
+	}()
+
+	// The connection begins with a greeting, echo it back
+	greeting, err := dbserver.ReadField(conn)
+	if err != nil {
+		return
+	}
+
+	if _, err := conn.Write(greeting.Bytes()); err != nil {
+		return
+	}
+
+	var pending []*dbserver.MenuItem
+
+	for {
+		req, err := dbserver.ReadMessage(conn)
+		if err != nil || req.Type == dbserver.MsgTeardown {
+			return
+		}
+
+		reply := encodeMessages(s.respond(req, &pending))
+
+		switch s.nextFault() {
+		case FaultClose:
+			return
+		case FaultTruncate:
+			conn.Write(reply[:len(reply)/2])
+			return
+		case FaultMalformed:
+			conn.Write(bytes.Repeat([]byte{0xff}, len(reply)))
+			return
+		}
+
+		if _, err := conn.Write(reply); err != nil {
+			return
+		}
+	}
+}
+
+// NewServer constructs a Server which identifies itself as the device ID.
+func NewServer(deviceID prolink.DeviceID) *Server {
+	return &Server{
+		deviceID: deviceID,
+		tracks:   map[uint32]*prolink.Track{},
+		cdTracks: map[uint32]*prolink.Track{},
+		data:     map[dataKey][]byte{},
+		conns:    map[net.Conn]bool{},
+	}
+}
@@ -0,0 +1,261 @@
+package prolink
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// How often to check if all replayed packets have been handled.
+const replayDrainInterval = 10 * time.Millisecond
+
+// The block type that pcapng files start with.
+const pcapngMagic = 0x0A0D0D0A
+
+// ReplayOptions configures how a capture is replayed using ConnectReplay.
+type ReplayOptions struct {
+	// Speed scales the time between replayed packets. A speed of 2 replays
+	// the capture twice as fast as it was recorded. Defaults to 1, replaying
+	// packets with their original timing.
+	Speed float64
+
+	// AsFastAsPossible replays packets without waiting between them. Speed
+	// is ignored when enabled.
+	AsFastAsPossible bool
+
+	// StatusDispatch configures how CDJ status updates are delivered to
+	// status handlers, see Config.StatusDispatch.
+	StatusDispatch DispatchConfig
+
+	// OnError is called when errors occur while replaying the capture, such
+	// as failing to parse packets.
+	OnError func(*NetworkError)
+
+	// OnFinish is called once every packet in the capture has been replayed
+	// and handled by the network. Handlers are called asynchronously, and may
+	// still be processing the last packets. The error is non-nil if the
+	// capture could not be read in full.
+	OnFinish func(error)
+}
+
+// captureReader reads packets from a pcap or pcapng file.
+type captureReader interface {
+	gopacket.PacketDataSource
+	LinkType() layers.LinkType
+}
+
+// newCaptureReader constructs a captureReader for the file, detecting if the
+// file is in the pcap or pcapng format.
+func newCaptureReader(r io.Reader) (captureReader, error) {
+	buf := bufio.NewReader(r)
+
+	magic, err := buf.Peek(4)
+	if err != nil {
+		return nil, err
+	}
+
+	// The pcapng block type is the same in either byte order
+	if binary.BigEndian.Uint32(magic) == pcapngMagic {
+		return pcapgo.NewNgReader(buf, pcapgo.DefaultNgReaderOptions)
+	}
+
+	return pcapgo.NewReader(buf)
+}
+
+// captureReplayer delivers packets read from a capture to the connections of
+// the network.
+type captureReplayer struct {
+	network *Network
+	reader  captureReader
+	options ReplayOptions
+	conns   map[int]*memoryConn
+
+	reportError errorReporter
+}
+
+// wait blocks until the packet captured at the offset from the first packet
+// should be replayed, relative to the start time. Returns false if the
+// network was closed while waiting.
+func (r *captureReplayer) wait(start time.Time, offset time.Duration) bool {
+	delay := time.Duration(0)
+
+	if !r.options.AsFastAsPossible {
+		speed := r.options.Speed
+		if speed <= 0 {
+			speed = 1
+		}
+
+		delay = time.Until(start.Add(time.Duration(float64(offset) / speed)))
+	}
+
+	if delay <= 0 {
+		select {
+		case <-r.network.done:
+			return false
+		default:
+			return true
+		}
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-r.network.done:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// drain blocks until all replayed packets have been read from the
+// connections and handled. Returns false if the network was closed while
+// waiting.
+func (r *captureReplayer) drain() bool {
+	ticker := time.NewTicker(replayDrainInterval)
+	defer ticker.Stop()
+
+	for {
+		pending := 0
+		for _, conn := range r.conns {
+			pending += conn.pending()
+		}
+
+		if pending == 0 {
+			return true
+		}
+
+		select {
+		case <-r.network.done:
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// replay reads every packet from the capture, delivering PRO DJ LINK packets
+// to the connection of their destination port.
+func (r *captureReplayer) replay() error {
+	var first time.Time
+	start := time.Now()
+
+	for {
+		data, info, err := r.reader.ReadPacketData()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("Failed to read packet from capture: %s", err)
+		}
+
+		packet := gopacket.NewPacket(data, r.reader.LinkType(), gopacket.NoCopy)
+
+		udpLayer, ok := packet.TransportLayer().(*layers.UDP)
+		if !ok {
+			continue
+		}
+
+		conn, ok := r.conns[int(udpLayer.DstPort)]
+		if !ok {
+			continue
+		}
+
+		if first.IsZero() {
+			first = info.Timestamp
+		}
+
+		if !r.wait(start, info.Timestamp.Sub(first)) {
+			return nil
+		}
+
+		conn.inject(udpLayer.Payload, packetSourceAddr(packet))
+	}
+}
+
+// run replays the capture and notifies the OnFinish handler once every
+// packet has been handled.
+func (r *captureReplayer) run(file io.Closer) {
+	defer file.Close()
+
+	err := r.replay()
+	if err != nil {
+		r.reportError(nil, nil, err)
+	}
+
+	if !r.drain() {
+		return
+	}
+
+	if r.options.OnFinish != nil {
+		r.options.OnFinish(err)
+	}
+}
+
+// ConnectReplay replays a PRO DJ LINK capture from a .pcap or .pcapng file,
+// returning a Network that reports the devices, status and beats found in the
+// capture as if they were live on the network. Packets are replayed using
+// their original timing unless configured otherwise in the ReplayOptions.
+//
+// No virtual CDJ is announced and remote databases are unavailable when
+// replaying. The network should be closed using Network.Close when it is no
+// longer needed, which will also stop the replay.
+func ConnectReplay(path string, opts ReplayOptions) (*Network, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open capture: %s", err)
+	}
+
+	reader, err := newCaptureReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("Failed to read capture: %s", err)
+	}
+
+	announceConn := newMemoryConn(announceAddr, nil)
+	beatConn := newMemoryConn(beatAddr, nil)
+	listenerConn := newMemoryConn(listenerAddr, nil)
+
+	network := &Network{
+		remoteDB:    newRemoteDB(),
+		cdjMonitor:  newCDJStatusMonitor(opts.StatusDispatch),
+		beatMonitor: newBeatMonitor(),
+		devManager:  newDeviceManager(),
+
+		announceConn: announceConn,
+		listenerConn: listenerConn,
+		beatConn:     beatConn,
+		done:         make(chan bool),
+		onError:      opts.OnError,
+	}
+
+	network.cdjMonitor.activate(listenerConn,
+		newErrorReporter(ErrorSourceStatusMonitor, opts.OnError))
+	network.beatMonitor.activate(beatConn,
+		newErrorReporter(ErrorSourceBeatMonitor, opts.OnError))
+	network.devManager.activate(announceConn, nil,
+		newErrorReporter(ErrorSourceDeviceManager, opts.OnError))
+
+	replayer := &captureReplayer{
+		network: network,
+		reader:  reader,
+		options: opts,
+		conns: map[int]*memoryConn{
+			announceAddr.Port: announceConn,
+			beatAddr.Port:     beatConn,
+			listenerAddr.Port: listenerConn,
+		},
+		reportError: newErrorReporter(ErrorSourceReplay, opts.OnError),
+	}
+
+	go replayer.run(file)
+
+	return network, nil
+}
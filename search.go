@@ -0,0 +1,63 @@
+package prolink
+
+import (
+	"strings"
+
+	"go.evanpurkhiser.com/prolink/dbserver"
+)
+
+// SearchResults is a page of tracks matching a search.
+type SearchResults struct {
+	// Tracks are summaries of the matching tracks. Only the ID, Title and the
+	// field shown alongside the title by the device, such as the Artist, are
+	// populated. Use GetTrack to retrieve the complete track metadata.
+	Tracks []*Track
+
+	// Total is the number of results matching the search, which may be more
+	// than the number of tracks rendered.
+	Total uint32
+}
+
+// trackSummary constructs a sparse Track from a menu item listing a track.
+func trackSummary(item *MenuItem) *Track {
+	track := &Track{ID: item.ID, Title: item.Label}
+
+	// The secondary label of track items is identified in the second byte of
+	// the item type
+	if field, ok := metadataLabels[item.Type>>8]; ok {
+		*field(track) = item.Label2
+	}
+
+	return track
+}
+
+// Search looks up tracks on the media in the slot of the device with a title
+// or artist matching the text. Results are paged, offset is the index of the
+// first result to render and limit is the number of results to render, when
+// zero all results after the offset are rendered.
+func (rd *RemoteDB) Search(devID DeviceID, slot TrackSlot, text string, offset, limit uint32) (*SearchResults, error) {
+	q := &BrowseQuery{
+		DeviceID: devID,
+		Slot:     slot,
+		Offset:   offset,
+		Limit:    limit,
+	}
+
+	// Players search using upper case text
+	search := dbserver.String(strings.ToUpper(text))
+
+	menu, err := rd.browse(q, dbserver.MsgSearchMenu, sortDefault, search.Size(), search, dbserver.Uint32(0))
+	if err != nil {
+		return nil, err
+	}
+
+	results := &SearchResults{Total: menu.Total, Tracks: []*Track{}}
+
+	for _, item := range menu.Items {
+		if item.Type.Base() == dbserver.ItemTitle {
+			results.Tracks = append(results.Tracks, trackSummary(item))
+		}
+	}
+
+	return results, nil
+}
@@ -2,12 +2,19 @@ package prolink
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
-	"io"
+	"math"
 	"strconv"
 )
 
+// Length of CDJ status packets, as reported by nexus players
+const cdjStatusPacketLen = 0x11C
+
+// Packet type byte of CDJ status packets
+const cdjStatusType byte = 0x0A
+
 // Status flag bitmasks
 const (
 	statusFlagOnAir   byte = 1 << 3
@@ -167,6 +174,50 @@ func packetToStatus(p []byte) (*CDJStatus, error) {
 	return status, nil
 }
 
+// MarshalBinary encodes the status as a CDJ status packet, in the same form
+// players report their status on the network.
+func (s *CDJStatus) MarshalBinary() ([]byte, error) {
+	b := binary.BigEndian
+	p := make([]byte, cdjStatusPacketLen)
+
+	copy(p, prolinkHeader)
+	p[0x0A] = cdjStatusType
+	p[0x20] = 0x03
+	p[0x21] = byte(s.PlayerID)
+	b.PutUint16(p[0x22:], cdjStatusPacketLen-0x24)
+	p[0x24] = byte(s.PlayerID)
+	p[0x28] = byte(s.TrackDevice)
+	p[0x29] = byte(s.TrackSlot)
+	b.PutUint32(p[0x2C:], s.TrackID)
+	p[0x7B] = byte(s.PlayState)
+
+	if s.PlayState == PlayStatePlaying || s.PlayState == PlayStateLooping {
+		p[0x89] |= statusFlagPlaying
+	}
+
+	if s.IsOnAir {
+		p[0x89] |= statusFlagOnAir
+	}
+
+	if s.IsSync {
+		p[0x89] |= statusFlagSync
+	}
+
+	if s.IsMaster {
+		p[0x89] |= statusFlagMaster
+	}
+
+	copy(p[0x8D:], pitchBytes(s.SliderPitch))
+	copy(p[0x92:], bpmBytes(s.TrackBPM))
+	copy(p[0x99:], pitchBytes(s.EffectivePitch))
+	b.PutUint32(p[0xA0:], s.Beat)
+	b.PutUint16(p[0xA4:], s.BeatsUntilCue)
+	p[0xA6] = s.BeatInMeasure
+	b.PutUint32(p[0xC8:], s.PacketNum)
+
+	return p, nil
+}
+
 // calcPitch converts a uint24 byte value into a flaot32 pitch.
 //
 // The pitch information ranges from 0x000000 (meaning -100%, complete stop) to
@@ -185,6 +236,27 @@ func calcBPM(p []byte) float32 {
 	return float32(binary.BigEndian.Uint16(p)) / 100
 }
 
+// pitchBytes converts a float32 pitch into a uint24 byte value. This is the
+// inverse of calcPitch.
+func pitchBytes(pitch float32) []byte {
+	v := math.Round(float64(pitch)/100*0x100000 + 0x100000)
+	v = math.Max(0, math.Min(v, 0x200000))
+
+	p := make([]byte, 4)
+	binary.BigEndian.PutUint32(p, uint32(v))
+
+	return p[1:]
+}
+
+// bpmBytes converts a float32 bpm into a uint16 byte value. This is the
+// inverse of calcBPM.
+func bpmBytes(bpm float32) []byte {
+	p := make([]byte, 2)
+	binary.BigEndian.PutUint16(p, uint16(math.Round(float64(bpm)*100)))
+
+	return p
+}
+
 // A StatusHandler responds to status updates on a CDJ.
 type StatusHandler interface {
 	OnStatusUpdate(*CDJStatus)
@@ -198,30 +270,134 @@ type StatusHandlerFunc func(*CDJStatus)
 func (f StatusHandlerFunc) OnStatusUpdate(s *CDJStatus) { f(s) }
 
 // CDJStatusMonitor provides an interface for watching for status updates to
-// CDJ devices on the PRO DJ LINK network.
+// CDJ and mixer devices on the PRO DJ LINK network.
 type CDJStatusMonitor struct {
-	handlers []StatusHandler
+	handlers        *handlerSet
+	mixerHandlers   *handlerSet
+	channelHandlers *handlerSet
+	dispatcher      *orderedDispatcher
+	done            chan bool
 }
 
 // OnStatusUpdate registers a StatusHandler to be called when any CDJ on the
 // PRO DJ LINK network reports its status.
-func (sm *CDJStatusMonitor) OnStatusUpdate(h StatusHandler) {
-	sm.handlers = append(sm.handlers, h)
+func (sm *CDJStatusMonitor) OnStatusUpdate(h StatusHandler) *Subscription {
+	return sm.handlers.add(h)
+}
+
+// OnMixerStatus registers a MixerStatusHandler to be called when any mixer on
+// the PRO DJ LINK network reports its status. Mixer status updates are
+// delivered to the handler in the order they were received.
+func (sm *CDJStatusMonitor) OnMixerStatus(h MixerStatusHandler) *Subscription {
+	return sm.mixerHandlers.add(h)
+}
+
+// DroppedUpdates reports the number of status updates dropped for each player
+// because a handler fell behind. Status updates are only dropped when using
+// ordered dispatch, see DispatchConfig.
+func (sm *CDJStatusMonitor) DroppedUpdates() map[DeviceID]uint64 {
+	if sm.dispatcher == nil {
+		return map[DeviceID]uint64{}
+	}
+
+	return sm.dispatcher.droppedUpdates()
+}
+
+// StatusUpdates returns a channel which receives the status of every CDJ on
+// the PRO DJ LINK network, in the order they were reported. The channel is
+// closed once the context is done.
+func (sm *CDJStatusMonitor) StatusUpdates(ctx context.Context, config ChannelConfig) <-chan *CDJStatus {
+	ch := make(chan *CDJStatus, config.bufferSize())
+	sender := newChannelSender(config, ctx.Done())
+
+	handler := func(status *CDJStatus) {
+		trySend := func() bool {
+			select {
+			case ch <- status:
+				return true
+			default:
+				return false
+			}
+		}
+
+		send := func(done <-chan struct{}) {
+			select {
+			case ch <- status:
+			case <-done:
+			}
+		}
+
+		dropOldest := func() bool {
+			select {
+			case <-ch:
+				return true
+			default:
+				return false
+			}
+		}
+
+		sender.deliver(trySend, send, dropOldest)
+	}
+
+	// Channels are delivered to as status packets are read, delivering never
+	// blocks unless using the Block policy.
+	sub := sm.channelHandlers.add(handler)
+
+	go func() {
+		<-ctx.Done()
+		sub.Unsubscribe()
+		sender.close(func() { close(ch) })
+	}()
+
+	return ch
+}
+
+// dispatchMixerStatus reports a mixer status packet to the registered mixer
+// status handlers.
+func (sm *CDJStatusMonitor) dispatchMixerStatus(p []byte) error {
+	status, err := packetToMixerStatus(p)
+	if err != nil {
+		return err
+	}
+
+	sm.mixerHandlers.notify(func(h interface{}) { h.(MixerStatusHandler).OnMixerStatus(status) })
+
+	return nil
 }
 
 // activate triggers the CDJStatusMonitor to begin listening for status packets
-// given a UDP connection to listen on.
-func (sm *CDJStatusMonitor) activate(listenConn io.Reader) {
+// given a UDP connection to listen on. Errors reading or parsing packets are
+// given to the errorReporter.
+func (sm *CDJStatusMonitor) activate(listenConn PacketListener, reportError errorReporter) {
 	packet := make([]byte, 512)
 
 	statusUpdateHandler := func() {
-		n, err := listenConn.Read(packet)
-		if err != nil || n == 0 {
+		n, addr, err := listenConn.ReadFrom(packet)
+		if err != nil {
+			select {
+			case <-sm.done:
+			default:
+				reportError(nil, nil, err)
+			}
+
+			return
+		}
+
+		if n == 0 {
+			return
+		}
+
+		if n > 0x0A && packet[0x0A] == mixerStatusType {
+			if err := sm.dispatchMixerStatus(packet[:n]); err != nil {
+				reportError(addr, packet[:n], err)
+			}
+
 			return
 		}
 
 		status, err := packetToStatus(packet[:n])
 		if err != nil {
+			reportError(addr, packet[:n], err)
 			return
 		}
 
@@ -229,18 +405,54 @@ func (sm *CDJStatusMonitor) activate(listenConn io.Reader) {
 			return
 		}
 
-		for _, h := range sm.handlers {
-			go h.OnStatusUpdate(status)
+		for _, h := range sm.channelHandlers.list() {
+			h.(func(*CDJStatus))(status)
+		}
+
+		if sm.dispatcher != nil {
+			sm.dispatcher.dispatch(sm.handlers.entryList(), status)
+			return
+		}
+
+		for _, h := range sm.handlers.list() {
+			go h.(StatusHandler).OnStatusUpdate(status)
 		}
 	}
 
 	go func() {
 		for {
-			statusUpdateHandler()
+			select {
+			case <-sm.done:
+				return
+			default:
+				statusUpdateHandler()
+			}
 		}
 	}()
 }
 
-func newCDJStatusMonitor() *CDJStatusMonitor {
-	return &CDJStatusMonitor{handlers: []StatusHandler{}}
+// deactivate stops the CDJStatusMonitor from listening for status packets.
+// The listening connection must be closed after deactivating to unblock any
+// pending reads.
+func (sm *CDJStatusMonitor) deactivate() {
+	close(sm.done)
+
+	if sm.dispatcher != nil {
+		sm.dispatcher.close()
+	}
+}
+
+func newCDJStatusMonitor(dispatch DispatchConfig) *CDJStatusMonitor {
+	sm := &CDJStatusMonitor{
+		handlers:        &handlerSet{},
+		mixerHandlers:   &handlerSet{},
+		channelHandlers: &handlerSet{},
+		done:            make(chan bool),
+	}
+
+	if dispatch.Ordered {
+		sm.dispatcher = newOrderedDispatcher(dispatch)
+	}
+
+	return sm
 }
@@ -0,0 +1,248 @@
+package prolink
+
+import (
+	"sync"
+)
+
+// Subscription represents a registered handler or listener. Unsubscribe may be
+// used to stop the handler from being called.
+type Subscription struct {
+	once        sync.Once
+	unsubscribe func()
+}
+
+// Unsubscribe removes the handler, it will no longer be called. It is safe to
+// call Unsubscribe multiple times.
+func (s *Subscription) Unsubscribe() {
+	s.once.Do(s.unsubscribe)
+}
+
+// eventQueue calls queued functions one at a time, in the order they were
+// pushed, without blocking the caller. A goroutine runs while functions are
+// queued. The queue is unbounded, it is used for events arriving at most a few
+// times a second, such as device changes, beats, mixer status and errors.
+type eventQueue struct {
+	lock    sync.Mutex
+	events  []func()
+	running bool
+	closed  bool
+}
+
+// push queues the function to be called.
+func (q *eventQueue) push(fn func()) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.closed {
+		return
+	}
+
+	q.events = append(q.events, fn)
+
+	if !q.running {
+		q.running = true
+		go q.run()
+	}
+}
+
+// run calls queued functions until the queue is empty.
+func (q *eventQueue) run() {
+	for {
+		q.lock.Lock()
+
+		if len(q.events) == 0 {
+			q.running = false
+			q.lock.Unlock()
+			return
+		}
+
+		fn := q.events[0]
+		q.events = q.events[1:]
+		q.lock.Unlock()
+
+		fn()
+	}
+}
+
+// close discards queued functions, no further functions will be called.
+func (q *eventQueue) close() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.closed = true
+	q.events = nil
+}
+
+// handlerEntry is a single handler registered in a handlerSet. Handlers
+// notified using handlerSet.notify are called through the queue.
+type handlerEntry struct {
+	id      uint64
+	handler interface{}
+	queue   *eventQueue
+}
+
+// handlerSet is a list of handlers which may be safely registered and removed
+// from multiple goroutines. Handlers are kept in the order they were added.
+type handlerSet struct {
+	lock    sync.Mutex
+	nextID  uint64
+	entries []handlerEntry
+}
+
+// add registers a handler, returning the Subscription used to remove it.
+func (s *handlerSet) add(h interface{}) *Subscription {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.nextID++
+	id := s.nextID
+
+	s.entries = append(s.entries, handlerEntry{id: id, handler: h, queue: &eventQueue{}})
+
+	return &Subscription{unsubscribe: func() { s.remove(id) }}
+}
+
+// remove unregisters the handler with the given ID.
+func (s *handlerSet) remove(id uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i, entry := range s.entries {
+		if entry.id == id {
+			entry.queue.close()
+			s.entries = append(s.entries[:i:i], s.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// notify calls fn with each registered handler. Each handler receives
+// notifications in the order they were made, one at a time, such that a slow
+// handler only delays itself.
+func (s *handlerSet) notify(fn func(h interface{})) {
+	for _, entry := range s.entryList() {
+		h := entry.handler
+		entry.queue.push(func() { fn(h) })
+	}
+}
+
+// entryList returns a snapshot of all registered handler entries.
+func (s *handlerSet) entryList() []handlerEntry {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]handlerEntry(nil), s.entries...)
+}
+
+// list returns a snapshot of all registered handlers.
+func (s *handlerSet) list() []interface{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	handlers := make([]interface{}, len(s.entries))
+	for i, entry := range s.entries {
+		handlers[i] = entry.handler
+	}
+
+	return handlers
+}
+
+// DropPolicy configures what happens when an event is delivered to a channel
+// whose buffer is full.
+type DropPolicy int
+
+// Defined drop policies.
+const (
+	// DropNewest discards the event being delivered.
+	DropNewest DropPolicy = iota
+
+	// DropOldest discards the oldest event in the buffer to make room for the
+	// event being delivered.
+	DropOldest
+
+	// Block waits until there is room in the buffer. Note that this will
+	// delay the delivery of events to other handlers of the same kind.
+	Block
+
+	// Coalesce discards all pending events whenever an event is delivered,
+	// such that only the latest event is kept.
+	Coalesce
+)
+
+// defaultChannelBuffer is the size of channel buffers when no buffer size is
+// configured.
+const defaultChannelBuffer = 16
+
+// ChannelConfig configures channels returned from methods such as
+// CDJStatusMonitor.StatusUpdates. The zero value buffers 16 events, dropping
+// new events while the buffer is full.
+type ChannelConfig struct {
+	// Buffer is the size of the channel buffer. Defaults to 16.
+	Buffer int
+
+	// DropPolicy configures what to do when the channel buffer is full.
+	DropPolicy DropPolicy
+}
+
+// bufferSize returns the configured buffer size, or the default.
+func (c ChannelConfig) bufferSize() int {
+	if c.Buffer <= 0 {
+		return defaultChannelBuffer
+	}
+
+	return c.Buffer
+}
+
+// channelSender delivers events to a channel according to a DropPolicy,
+// making sure no events are sent once the channel has been closed.
+type channelSender struct {
+	policy DropPolicy
+	done   <-chan struct{}
+	lock   sync.Mutex
+	closed bool
+}
+
+// deliver sends an event to the channel. trySend attempts to send the event
+// without blocking, returning false if the buffer is full. send blocks until
+// the event is sent or the channel is being closed. dropOldest removes the
+// oldest event from the buffer, returning false if the buffer is empty.
+func (cs *channelSender) deliver(trySend func() bool, send func(done <-chan struct{}), dropOldest func() bool) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	if cs.closed {
+		return
+	}
+
+	switch cs.policy {
+	case Block:
+		send(cs.done)
+	case DropOldest:
+		if !trySend() {
+			dropOldest()
+			trySend()
+		}
+	case Coalesce:
+		for dropOldest() {
+		}
+		trySend()
+	default:
+		trySend()
+	}
+}
+
+// close marks the sender as closed and closes the channel using closeChan.
+func (cs *channelSender) close(closeChan func()) {
+	cs.lock.Lock()
+	defer cs.lock.Unlock()
+
+	cs.closed = true
+	closeChan()
+}
+
+func newChannelSender(config ChannelConfig, done <-chan struct{}) *channelSender {
+	return &channelSender{
+		policy: config.DropPolicy,
+		done:   done,
+	}
+}
@@ -99,6 +99,9 @@ func NewHandler(config Config, fn HandlerFunc) *Handler {
 //   (cued, reached the end of the track, or a new track was loaded.
 //
 // - A track will be reported as ComingSoon when a new track is selected.
+//
+// Status updates should be delivered to the Handler in the order they were
+// reported, enable DispatchConfig.Ordered in the prolink.Config.
 type Handler struct {
 	config  Config
 	handler HandlerFunc
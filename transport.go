@@ -0,0 +1,94 @@
+package prolink
+
+import (
+	"fmt"
+	"net"
+)
+
+// Transport provides the connections used to communicate with the PRO DJ LINK
+// network. By default UDP sockets bound to the configured network interface
+// are used, an alternative Transport may be provided using Config.Transport,
+// such as a MemoryTransport for testing.
+type Transport interface {
+	// LocalAddr reports the IP and hardware address the virtual CDJ will be
+	// announced with.
+	LocalAddr() (net.IP, net.HardwareAddr, error)
+
+	// ListenAnnounce opens the connection used to send and receive packets on
+	// the announce port (50000).
+	ListenAnnounce() (net.PacketConn, error)
+
+	// ListenBeat opens the listener used to receive beat packets (50001).
+	ListenBeat() (PacketListener, error)
+
+	// ListenStatus opens the listener used to receive status packets (50002).
+	ListenStatus() (PacketListener, error)
+
+	// Dial opens a TCP connection to the address of a remote database server,
+	// given as a host:port pair.
+	Dial(address string) (net.Conn, error)
+}
+
+// udpTransport is the default Transport, communicating using UDP sockets bound
+// to a network interface.
+type udpTransport struct {
+	iface *net.Interface
+	sniff bool
+}
+
+// LocalAddr implements the Transport interface. The first IPv4 address of the
+// interface is used.
+func (t *udpTransport) LocalAddr() (net.IP, net.HardwareAddr, error) {
+	addrs, err := t.iface.Addrs()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	isLoopback := t.iface.Flags&net.FlagLoopback != 0
+
+	// The loopback interface does not have a hardware address
+	mac := t.iface.HardwareAddr
+	if len(mac) < 6 {
+		mac = make(net.HardwareAddr, 6)
+	}
+
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if ok && ipNet.IP.To4() != nil && (isLoopback || !ipNet.IP.IsLoopback()) {
+			return ipNet.IP, mac, nil
+		}
+	}
+
+	return nil, nil, fmt.Errorf("No IPv4 broadcast interface available")
+}
+
+// ListenAnnounce implements the Transport interface.
+func (t *udpTransport) ListenAnnounce() (net.PacketConn, error) {
+	return listenUDP(t.iface, announceAddr)
+}
+
+// ListenBeat implements the Transport interface.
+func (t *udpTransport) ListenBeat() (PacketListener, error) {
+	return openListener(t.iface, beatAddr, t.sniff)
+}
+
+// ListenStatus implements the Transport interface.
+func (t *udpTransport) ListenStatus() (PacketListener, error) {
+	return openListener(t.iface, listenerAddr, t.sniff)
+}
+
+// Dial implements the Transport interface.
+func (t *udpTransport) Dial(address string) (net.Conn, error) {
+	return net.Dial("tcp", address)
+}
+
+// newUDPTransport constructs the default Transport for the named network
+// interface. When no name is given the first broadcast interface is used.
+func newUDPTransport(ifaceName string, sniff bool) (*udpTransport, error) {
+	iface, err := getBroadcastInterface(ifaceName)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get broadcast interface: %s", err)
+	}
+
+	return &udpTransport{iface: iface, sniff: sniff}, nil
+}
@@ -0,0 +1,385 @@
+package prolink
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image/color"
+
+	"go.evanpurkhiser.com/prolink/anlz"
+	"go.evanpurkhiser.com/prolink/dbserver"
+)
+
+// WaveformStyle identifies the style of a waveform.
+type WaveformStyle int
+
+// Known waveform styles.
+const (
+	// WaveformMonochrome is the blue waveform shown by all players, where the
+	// whiteness of each segment indicates its frequency content.
+	WaveformMonochrome WaveformStyle = iota
+
+	// WaveformColor is the RGB waveform shown by nexus 2 players.
+	WaveformColor
+
+	// WaveformThreeBand is the waveform of the low, mid and high frequency
+	// bands shown by CDJ-3000 players.
+	WaveformThreeBand
+)
+
+// waveformPreviewSegments is the number of segments of a monochrome waveform
+// preview.
+const waveformPreviewSegments = 400
+
+// waveformDetailHeaderSize is the number of bytes preceding the segments of a
+// monochrome detailed waveform.
+const waveformDetailHeaderSize = 0x13
+
+// anlzTagPrefixSize is the number of bytes preceding the analysis file tag in
+// the data served for a MsgAnlzTag request.
+const anlzTagPrefixSize = 4
+
+// anlzTagHeaderSize is the size of the header of the waveform analysis file
+// tags encoded by MarshalBinary.
+const anlzTagHeaderSize = 0x18
+
+// Colors of the monochrome and three band waveforms.
+var (
+	waveformBlue = color.RGBA{0x00, 0x68, 0x90, 0xff}
+	waveformLow  = color.RGBA{0x20, 0x53, 0xd9, 0xff}
+	waveformMid  = color.RGBA{0xf2, 0xaa, 0x3c, 0xff}
+	waveformHigh = color.RGBA{0xff, 0xff, 0xff, 0xff}
+)
+
+// WaveformSegment is a single column of a waveform.
+type WaveformSegment struct {
+	// Height of the segment, at most the MaxHeight of the waveform.
+	Height uint8
+
+	// Color the segment is drawn in.
+	Color color.RGBA
+
+	// Low, Mid and High are the heights of each frequency band of three band
+	// waveforms, Height is the tallest of the bands.
+	Low  uint8
+	Mid  uint8
+	High uint8
+}
+
+// Waveform is the waveform of a track, as analyzed by rekordbox.
+type Waveform struct {
+	Style WaveformStyle
+
+	// Detail is set for detailed waveforms, which have a segment for every
+	// 150th of a second of the track. Previews summarize the entire track in
+	// a fixed number of segments.
+	Detail bool
+
+	Segments []WaveformSegment
+}
+
+// MaxHeight is the largest height a segment of the waveform may have.
+func (w *Waveform) MaxHeight() uint8 {
+	if w.Style == WaveformMonochrome || w.Style == WaveformColor && w.Detail {
+		return 0x1f
+	}
+
+	return 0xff
+}
+
+// anlzTag returns the code of the analysis file tag and the extension of the
+// analysis file holding the waveform. The remote database serves monochrome
+// waveforms using their own requests.
+func (w *Waveform) anlzTag() (tag, ext string) {
+	switch {
+	case w.Style == WaveformMonochrome && !w.Detail:
+		return anlz.TagWaveformPreview, "DAT"
+	case w.Style == WaveformMonochrome:
+		return anlz.TagWaveformDetail, "EXT"
+	case w.Style == WaveformColor && !w.Detail:
+		return anlz.TagWaveformColorPreview, "EXT"
+	case w.Style == WaveformColor:
+		return anlz.TagWaveformColorDetail, "EXT"
+	case w.Style == WaveformThreeBand && !w.Detail:
+		return anlz.TagWaveform3BandPreview, "2EX"
+	default:
+		return anlz.TagWaveform3BandDetail, "2EX"
+	}
+}
+
+// entrySize is the number of bytes describing each segment of the waveform.
+func (w *Waveform) entrySize() int {
+	switch w.Style {
+	case WaveformMonochrome:
+		if w.Detail {
+			return 1
+		}
+		return 2
+	case WaveformColor:
+		if w.Detail {
+			return 2
+		}
+		return 6
+	default:
+		return 3
+	}
+}
+
+// whiteness blends the color of monochrome waveforms towards white by the
+// whiteness, from 0 to 7.
+func whiteness(w uint8) color.RGBA {
+	blend := func(c uint8) uint8 {
+		return c + uint8(uint16(0xff-c)*uint16(w)/7)
+	}
+
+	return color.RGBA{blend(waveformBlue.R), blend(waveformBlue.G), blend(waveformBlue.B), 0xff}
+}
+
+// scale converts a value in the range of zero to from into the range of zero
+// to to, rounding to the nearest value.
+func scale(v, from, to uint8) uint8 {
+	if from == 0 {
+		return 0
+	}
+
+	return uint8((uint16(v)*uint16(to) + uint16(from)/2) / uint16(from))
+}
+
+// maxOf returns the largest of the values.
+func maxOf(values ...uint8) uint8 {
+	var largest uint8
+	for _, v := range values {
+		if v > largest {
+			largest = v
+		}
+	}
+
+	return largest
+}
+
+// decodeSegment decodes a single segment of the waveform. Monochrome previews
+// read from analysis files pack the whiteness and height of each segment into
+// a single byte, as detailed monochrome waveforms do.
+func (w *Waveform) decodeSegment(entry []byte) WaveformSegment {
+	switch {
+	case w.Style == WaveformMonochrome && len(entry) == 1:
+		return WaveformSegment{Height: entry[0] & 0x1f, Color: whiteness(entry[0] >> 5)}
+	case w.Style == WaveformMonochrome:
+		return WaveformSegment{Height: entry[0] & 0x1f, Color: whiteness(entry[1] & 0x07)}
+	case w.Style == WaveformColor && w.Detail:
+		bits := binary.BigEndian.Uint16(entry)
+
+		return WaveformSegment{
+			Height: uint8(bits>>2) & 0x1f,
+			Color: color.RGBA{
+				R: scale(uint8(bits>>13)&0x07, 7, 0xff),
+				G: scale(uint8(bits>>10)&0x07, 7, 0xff),
+				B: scale(uint8(bits>>7)&0x07, 7, 0xff),
+				A: 0xff,
+			},
+		}
+	case w.Style == WaveformColor:
+		// The last three bytes of color preview segments are the intensity
+		// of the red, green and blue components of the segment.
+		height := maxOf(entry[3], entry[4], entry[5])
+
+		return WaveformSegment{
+			Height: height,
+			Color: color.RGBA{
+				R: scale(entry[3], height, 0xff),
+				G: scale(entry[4], height, 0xff),
+				B: scale(entry[5], height, 0xff),
+				A: 0xff,
+			},
+		}
+	}
+
+	s := WaveformSegment{Mid: entry[0], High: entry[1], Low: entry[2]}
+	s.Height = maxOf(s.Low, s.Mid, s.High)
+
+	switch s.Height {
+	case s.Low:
+		s.Color = waveformLow
+	case s.Mid:
+		s.Color = waveformMid
+	default:
+		s.Color = waveformHigh
+	}
+
+	return s
+}
+
+// encodeSegment encodes a single segment of the waveform into the entry.
+func (w *Waveform) encodeSegment(s WaveformSegment, entry []byte) {
+	switch {
+	case w.Style == WaveformMonochrome && w.Detail:
+		entry[0] = scale(s.Color.R, 0xff, 7)<<5 | s.Height&0x1f
+	case w.Style == WaveformMonochrome:
+		entry[0] = s.Height & 0x1f
+		entry[1] = scale(s.Color.R, 0xff, 7)
+	case w.Style == WaveformColor && w.Detail:
+		bits := uint16(scale(s.Color.R, 0xff, 7))<<13 |
+			uint16(scale(s.Color.G, 0xff, 7))<<10 |
+			uint16(scale(s.Color.B, 0xff, 7))<<7 |
+			uint16(s.Height&0x1f)<<2
+
+		binary.BigEndian.PutUint16(entry, bits)
+	case w.Style == WaveformColor:
+		entry[3] = scale(s.Color.R, 0xff, s.Height)
+		entry[4] = scale(s.Color.G, 0xff, s.Height)
+		entry[5] = scale(s.Color.B, 0xff, s.Height)
+	default:
+		entry[0] = s.Mid
+		entry[1] = s.High
+		entry[2] = s.Low
+	}
+}
+
+// MarshalBinary encodes the waveform in the form served by the remote
+// database.
+func (w *Waveform) MarshalBinary() ([]byte, error) {
+	size := w.entrySize()
+
+	if w.Style == WaveformMonochrome && !w.Detail {
+		if len(w.Segments) > waveformPreviewSegments {
+			return nil, fmt.Errorf("Waveform preview has %d segments, at most %d are allowed", len(w.Segments), waveformPreviewSegments)
+		}
+
+		// The preview is followed by a tiny preview of 100 bytes, which is
+		// left empty.
+		data := make([]byte, waveformPreviewSegments*size+100)
+		for i, s := range w.Segments {
+			w.encodeSegment(s, data[i*size:])
+		}
+
+		return data, nil
+	}
+
+	offset := waveformDetailHeaderSize
+	if w.Style != WaveformMonochrome {
+		offset = anlzTagPrefixSize + anlzTagHeaderSize
+	}
+
+	data := make([]byte, offset+len(w.Segments)*size)
+	for i, s := range w.Segments {
+		w.encodeSegment(s, data[offset+i*size:])
+	}
+
+	if w.Style == WaveformMonochrome {
+		return data, nil
+	}
+
+	tag, _ := w.anlzTag()
+	header := data[anlzTagPrefixSize:]
+
+	binary.LittleEndian.PutUint32(data, uint32(len(data)-anlzTagPrefixSize))
+	copy(header, tag)
+	binary.BigEndian.PutUint32(header[0x04:], anlzTagHeaderSize)
+	binary.BigEndian.PutUint32(header[0x08:], uint32(len(header)))
+	binary.BigEndian.PutUint32(header[0x0c:], uint32(size))
+	binary.BigEndian.PutUint32(header[0x10:], uint32(len(w.Segments)))
+
+	return data, nil
+}
+
+// newWaveform decodes the waveform from its segment entries.
+func newWaveform(style WaveformStyle, detail bool, entries []byte, size int) *Waveform {
+	w := &Waveform{Style: style, Detail: detail, Segments: []WaveformSegment{}}
+
+	for i := 0; i+size <= len(entries); i += size {
+		w.Segments = append(w.Segments, w.decodeSegment(entries[i:i+size]))
+	}
+
+	return w
+}
+
+// parseWaveform decodes waveform data served by the remote database.
+func parseWaveform(style WaveformStyle, detail bool, data []byte) (*Waveform, error) {
+	w := &Waveform{Style: style, Detail: detail}
+	size := w.entrySize()
+
+	switch {
+	case style == WaveformMonochrome && detail:
+		if len(data) < waveformDetailHeaderSize {
+			return nil, fmt.Errorf("Waveform is too short (%d bytes)", len(data))
+		}
+
+		data = data[waveformDetailHeaderSize:]
+	case style == WaveformMonochrome:
+		if len(data) > waveformPreviewSegments*size {
+			data = data[:waveformPreviewSegments*size]
+		}
+	default:
+		if len(data) < anlzTagPrefixSize {
+			return nil, fmt.Errorf("Waveform is too short (%d bytes)", len(data))
+		}
+
+		tag, _, err := anlz.ParseTag(data[anlzTagPrefixSize:])
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse waveform: %s", err)
+		}
+
+		if kind, _ := w.anlzTag(); tag.Kind != kind {
+			return nil, fmt.Errorf("Waveform is not a %s tag", kind)
+		}
+
+		entries, err := anlz.ParseWaveform(tag)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse waveform: %s", err)
+		}
+
+		data = entries.Entries
+	}
+
+	return newWaveform(style, detail, data, size), nil
+}
+
+// getWaveform queries the remote database for the waveform.
+func (rd *RemoteDB) getWaveform(q *TrackQuery, style WaveformStyle, detail bool) (*Waveform, error) {
+	w := &Waveform{Style: style, Detail: detail}
+
+	var data []byte
+	var err error
+
+	switch {
+	case style == WaveformMonochrome && detail:
+		data, err = rd.dataRequest(q, dbserver.MsgWaveformDetail, dbserver.MsgWaveformDetailData,
+			dbserver.Uint32(q.TrackID),
+			dbserver.Uint32(0),
+		)
+	case style == WaveformMonochrome:
+		data, err = rd.dataRequest(q, dbserver.MsgWaveformPreview, dbserver.MsgWaveformPreviewData,
+			dbserver.Uint32(1),
+			dbserver.Uint32(q.TrackID),
+			dbserver.Uint32(0),
+		)
+	default:
+		tag, ext := w.anlzTag()
+
+		data, err = rd.dataRequest(q, dbserver.MsgAnlzTag, dbserver.MsgAnlzTagData,
+			dbserver.Uint32(q.TrackID),
+			dbserver.AnlzCode(tag),
+			dbserver.AnlzCode(ext),
+		)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return parseWaveform(style, detail, data)
+}
+
+// GetWaveformPreview queries the remote database for the waveform preview of
+// the track in the style. Monochrome previews have 400 segments, color and
+// three band previews have 1200 segments. ErrUnavailable is returned when the
+// track has not been analyzed, or the style is not available.
+func (rd *RemoteDB) GetWaveformPreview(q *TrackQuery, style WaveformStyle) (*Waveform, error) {
+	return rd.getWaveform(q, style, false)
+}
+
+// GetWaveformDetail queries the remote database for the detailed waveform of
+// the track in the style. ErrUnavailable is returned when the track has not
+// been analyzed, or the style is not available.
+func (rd *RemoteDB) GetWaveformDetail(q *TrackQuery, style WaveformStyle) (*Waveform, error) {
+	return rd.getWaveform(q, style, true)
+}
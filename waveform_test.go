@@ -0,0 +1,155 @@
+package prolink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+// anlzTagFixture constructs the data served for a MsgAnlzTag request of a
+// waveform tag.
+func anlzTagFixture(kind string, size int, entries []byte) []byte {
+	data := make([]byte, anlzTagPrefixSize+anlzTagHeaderSize, anlzTagPrefixSize+anlzTagHeaderSize+len(entries))
+	data = append(data, entries...)
+
+	header := data[anlzTagPrefixSize:]
+	copy(header, kind)
+	binary.BigEndian.PutUint32(header[0x04:], anlzTagHeaderSize)
+	binary.BigEndian.PutUint32(header[0x08:], uint32(len(header)))
+	binary.BigEndian.PutUint32(header[0x0c:], uint32(size))
+	binary.BigEndian.PutUint32(header[0x10:], uint32(len(entries)/size))
+
+	return data
+}
+
+func TestParseWaveform(t *testing.T) {
+	white := color.RGBA{0xff, 0xff, 0xff, 0xff}
+
+	tests := []struct {
+		name   string
+		style  WaveformStyle
+		detail bool
+		data   []byte
+		want   []WaveformSegment
+	}{
+		{
+			name:  "monochrome preview",
+			style: WaveformMonochrome,
+			data:  []byte{0x1f, 0x07, 0x05, 0x00},
+			want: []WaveformSegment{
+				{Height: 31, Color: white},
+				{Height: 5, Color: waveformBlue},
+			},
+		},
+		{
+			name:   "monochrome detail",
+			style:  WaveformMonochrome,
+			detail: true,
+			data:   append(make([]byte, waveformDetailHeaderSize), 0xff, 0x0a),
+			want: []WaveformSegment{
+				{Height: 31, Color: white},
+				{Height: 10, Color: waveformBlue},
+			},
+		},
+		{
+			name:  "color preview",
+			style: WaveformColor,
+			data:  anlzTagFixture("PWV4", 6, []byte{0x00, 0x00, 0x00, 0x40, 0x20, 0x10}),
+			want: []WaveformSegment{
+				{Height: 0x40, Color: color.RGBA{0xff, 0x80, 0x40, 0xff}},
+			},
+		},
+		{
+			name:   "color detail",
+			style:  WaveformColor,
+			detail: true,
+			data:   anlzTagFixture("PWV5", 2, []byte{0xe0, 0x7c}),
+			want: []WaveformSegment{
+				{Height: 31, Color: color.RGBA{0xff, 0x00, 0x00, 0xff}},
+			},
+		},
+		{
+			name:   "three band detail",
+			style:  WaveformThreeBand,
+			detail: true,
+			data:   anlzTagFixture("PWV7", 3, []byte{0x10, 0x80, 0x40, 0x60, 0x00, 0x20}),
+			want: []WaveformSegment{
+				{Height: 0x80, Color: waveformHigh, Mid: 0x10, High: 0x80, Low: 0x40},
+				{Height: 0x60, Color: waveformMid, Mid: 0x60, Low: 0x20},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := parseWaveform(tt.style, tt.detail, tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(w.Segments, tt.want) {
+				t.Errorf("got %+v, want %+v", w.Segments, tt.want)
+			}
+		})
+	}
+
+	// A tag of another style is rejected
+	if _, err := parseWaveform(WaveformColor, false, anlzTagFixture("PWV7", 3, []byte{0x10, 0x80, 0x40})); err == nil {
+		t.Error("expected an error for an unexpected tag")
+	}
+}
+
+func TestWaveformMarshalBinary(t *testing.T) {
+	styles := []struct {
+		style  WaveformStyle
+		detail bool
+	}{
+		{WaveformMonochrome, false},
+		{WaveformMonochrome, true},
+		{WaveformColor, false},
+		{WaveformColor, true},
+		{WaveformThreeBand, false},
+		{WaveformThreeBand, true},
+	}
+
+	for _, s := range styles {
+		w := &Waveform{Style: s.style, Detail: s.detail}
+
+		data := make([]byte, 0)
+		for i := 0; i < 8*w.entrySize(); i++ {
+			data = append(data, byte(i*37))
+		}
+
+		w = newWaveform(s.style, s.detail, data, w.entrySize())
+
+		encoded, err := w.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := parseWaveform(s.style, s.detail, encoded)
+		if err != nil {
+			t.Fatalf("style %d, detail %t: %s", s.style, s.detail, err)
+		}
+
+		// Monochrome previews are always served with 400 segments
+		if s.style == WaveformMonochrome && !s.detail {
+			got.Segments = got.Segments[:len(w.Segments)]
+		}
+
+		reencoded, err := got.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(reencoded, encoded) {
+			t.Errorf("style %d, detail %t: encoding is not stable", s.style, s.detail)
+		}
+
+		if len(got.Segments) != len(w.Segments) {
+			t.Errorf("style %d, detail %t: got %d segments, want %d", s.style, s.detail, len(got.Segments), len(w.Segments))
+		}
+	}
+}